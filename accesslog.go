@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+)
+
+// accessLog writes one line per request to out in Apache's common or
+// combined format, followed by the duration in microseconds (Apache's
+// %D), e.g.
+//
+//	203.0.113.7 - - [14/Oct/2026:10:00:00 -0300] "GET /cep/01001000 HTTP/1.1" 200 142 1532
+//
+// The combined format adds the quoted Referer and User-Agent before the
+// duration.
+func accessLog(out io.Writer, format string, next http.Handler) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		size := "-"
+		if rec.size > 0 {
+			size = strconv.FormatInt(rec.size, 10)
+		}
+		line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+			clientIP(r), start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, r.URL.RequestURI(), r.Proto, rec.status, size)
+		if format == accessLogCombined {
+			line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+		}
+		line += fmt.Sprintf(" %d\n", time.Since(start).Microseconds())
+
+		mu.Lock()
+		io.WriteString(out, line)
+		mu.Unlock()
+	})
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// statusRecorder captures the status and body size the handler sent.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	// Informational 1xx responses other than 101 come before the real one.
+	if w.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// addressSearcher finds the CEPs of a street; cep.ViaCep implements it.
+type addressSearcher interface {
+	Search(ctx context.Context, uf, city, street string) ([]cep.Address, error)
+}
+
+// handleAddress is the reverse of handleCEP: given a state, city and street
+// it returns every matching address, as found by ViaCep.
+func (s *server) handleAddress(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	uf := strings.ToUpper(strings.TrimSpace(q.Get("uf")))
+	city := strings.TrimSpace(q.Get("city"))
+	street := strings.TrimSpace(q.Get("street"))
+	if problems := validateAddressQuery(uf, city, street); len(problems) > 0 {
+		writeFieldErrors(w, r, errCodeInvalidAddress, problems)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.lookupTimeout(r.Context()))
+	defer cancel()
+	addresses, err := s.searcher.Search(ctx, uf, city, street)
+	if err != nil {
+		slog.InfoContext(r.Context(), "busca por endereço falhou", "uf", uf, "city", city, "street", street, "err", err)
+		status, errCode, message := lookupError(r.Context(), err)
+		writeError(w, r, status, errCode, message)
+		return
+	}
+	slog.InfoContext(r.Context(), "busca por endereço", "uf", uf, "city", city, "street", street, "results", len(addresses))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addresses)
+}
+
+// validateAddressQuery applies ViaCep's own limits so bad searches fail
+// here instead of upstream. It returns every problem found, none when the
+// query is valid.
+func validateAddressQuery(uf, city, street string) []fieldError {
+	var problems []fieldError
+	switch {
+	case uf == "":
+		problems = append(problems, fieldError{"uf", "uf é obrigatório, ex. SP"})
+	case len(uf) != 2 || !isUpperASCII(uf):
+		problems = append(problems, fieldError{"uf", "uf deve ter 2 letras, ex. SP"})
+	case cep.StateRanges(uf) == nil:
+		problems = append(problems, fieldError{"uf", fmt.Sprintf("uf %s não é a sigla de um estado", uf)})
+	}
+	for _, f := range []struct{ name, value string }{{"city", city}, {"street", street}} {
+		switch n := utf8.RuneCountInString(f.value); {
+		case n == 0:
+			problems = append(problems, fieldError{f.name, f.name + " é obrigatório"})
+		case n < 3:
+			problems = append(problems, fieldError{f.name, fmt.Sprintf("%s deve ter ao menos 3 caracteres, tem %d", f.name, n)})
+		}
+	}
+	return problems
+}
+
+func isUpperASCII(s string) bool {
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
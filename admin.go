@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// admin guards the operational endpoints with the configured bearer
+// token. The routes are only registered when a token is set.
+func (s *server) admin(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Token de administração ausente ou inválido")
+			return
+		}
+		h(w, r)
+	})
+}
+
+// handleCacheDelete serves DELETE /cache/{cep}, evicting one entry.
+// Evicting a CEP that isn't cached, or with the cache disabled, is a no-op.
+func (s *server) handleCacheDelete(w http.ResponseWriter, r *http.Request) {
+	code, err := cep.Normalize(r.PathValue("cep"))
+	if err != nil {
+		status, errCode := normalizeError(err)
+		writeError(w, r, status, errCode, err.Error())
+		return
+	}
+	if s.cache != nil {
+		if err := s.cache.Delete(r.Context(), code); err != nil {
+			slog.ErrorContext(r.Context(), "erro ao remover do cache", "cep", code, "err", err)
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "Erro ao acessar o cache")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCacheClear serves DELETE /cache, flushing every entry.
+func (s *server) handleCacheClear(w http.ResponseWriter, r *http.Request) {
+	if s.cache != nil {
+		if err := s.cache.Clear(r.Context()); err != nil {
+			slog.ErrorContext(r.Context(), "erro ao limpar o cache", "err", err)
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "Erro ao acessar o cache")
+			return
+		}
+		slog.InfoContext(r.Context(), "cache limpo")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type warmResponse struct {
+	Warmed int         `json:"warmed"`
+	Failed []batchItem `json:"failed"`
+}
+
+// handleCacheWarm serves POST /cache/warm, resolving a JSON array of CEPs
+// so the following requests for them are cache hits. CEPs already cached
+// cost nothing. With the cache disabled there is nothing to warm and no
+// lookup is made.
+func (s *server) handleCacheWarm(w http.ResponseWriter, r *http.Request) {
+	ceps, ok := s.readCEPList(w, r)
+	if !ok {
+		return
+	}
+	if s.cache == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp := s.warm(r.Context(), ceps)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// warm looks ceps up, at most batchConcurrency at a time like a batch, so
+// they land in the cache, and reports how many made it and which failed,
+// the invalid CEPs first.
+func (s *server) warm(ctx context.Context, ceps []string) warmResponse {
+	resp := warmResponse{Failed: []batchItem{}}
+	items := make([]batchItem, 0, len(ceps))
+	var lookups []int
+	seen := make(map[string]bool, len(ceps))
+	for _, raw := range ceps {
+		code, err := cep.Normalize(raw)
+		if err != nil {
+			resp.Failed = append(resp.Failed, invalidItem(raw, err))
+			continue
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		lookups = append(lookups, len(items))
+		items = append(items, batchItem{Cep: code})
+	}
+
+	done := s.concurrently(lookups, func(i int) {
+		if result := s.lookup(ctx, items[i].Cep); result.Err != nil {
+			items[i].Status, items[i].Erro = itemStatus(result.Err), result.Err.Error()
+		} else {
+			items[i].Status = itemOK
+		}
+	})
+	for i := range done {
+		if items[i].Status == itemOK {
+			resp.Warmed++
+		} else {
+			resp.Failed = append(resp.Failed, items[i])
+		}
+	}
+	return resp
+}
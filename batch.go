@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// Per-item outcomes of a batch.
+const (
+	itemOK       = "ok"
+	itemNotFound = "not_found"
+	itemTimeout  = "timeout"
+	itemError    = "error"
+)
+
+type batchItem struct {
+	Cep    string       `json:"cep"`
+	Status string       `json:"status"`
+	Origem string       `json:"origem,omitempty"`
+	Data   *cep.Address `json:"data,omitempty"`
+	// Provenance is where the answer came from, as in resultadoAPI.
+	Provenance string `json:"source,omitempty"`
+	DurationMs int64  `json:"duracao_ms,omitempty"`
+	Erro       string `json:"erro,omitempty"`
+	// CachedAt marks a stale answer, as in resultadoAPI.
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+}
+
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	ceps, ok := s.readCEPList(w, r)
+	if !ok {
+		return
+	}
+
+	// Only the workers touch the items in lookups once they start, so the
+	// invalid ones are tracked apart.
+	items := make([]batchItem, 0, len(ceps))
+	var lookups, invalid []int
+	seen := make(map[string]bool, len(ceps))
+	for _, raw := range ceps {
+		code, err := cep.Normalize(raw)
+		if err != nil {
+			invalid = append(invalid, len(items))
+			items = append(items, invalidItem(raw, err))
+			continue
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		lookups = append(lookups, len(items))
+		items = append(items, batchItem{Cep: code})
+	}
+
+	// CSV and NDJSON are streamed: emit writes each item as it finishes,
+	// so clients can start on the results before the batch is done.
+	var emit func(batchItem)
+	switch accept := r.Header.Get("Accept"); {
+	case accepts(accept, "text/csv"):
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(batchCSVHeader)
+		emit = func(it batchItem) {
+			cw.Write(it.csvRecord())
+			cw.Flush()
+		}
+	case accepts(accept, "application/x-ndjson"):
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		emit = func(it batchItem) { enc.Encode(it) }
+	}
+
+	// Those that failed validation are already finished; the others are
+	// reported on done as they complete.
+	done := s.resolveItems(r, items, lookups)
+
+	if emit != nil {
+		flush := func() {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		for _, i := range invalid {
+			emit(items[i])
+		}
+		flush()
+		for i := range done {
+			emit(items[i])
+			flush()
+		}
+		return
+	}
+	for range done {
+		// The JSON array keeps the request order, so wait for everything.
+	}
+	// A streamed response has already gone out as 200 by now and carries
+	// each item's status.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(batchStatus(items))
+	json.NewEncoder(w).Encode(items)
+}
+
+// serveCEPList answers /cep/{cep} when it lists several CEPs separated by
+// commas, with a JSON array like /cep/batch gives. Unlike the batch, a
+// single invalid CEP rejects the list, with a detail naming each one.
+func (s *server) serveCEPList(w http.ResponseWriter, r *http.Request, raw string) {
+	parts := strings.Split(raw, ",")
+	if len(parts) > s.batchMax {
+		writeError(w, r, http.StatusRequestEntityTooLarge, errCodeBatchTooLarge, fmt.Sprintf("Lista excede o limite de %d CEPs", s.batchMax))
+		return
+	}
+
+	items := make([]batchItem, 0, len(parts))
+	var lookups []int
+	var problems []fieldError
+	seen := make(map[string]bool, len(parts))
+	for i, part := range parts {
+		code, err := cep.Normalize(strings.TrimSpace(part))
+		if err != nil {
+			problems = append(problems, fieldError{Field: fmt.Sprintf("cep[%d]", i), Message: err.Error()})
+			continue
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		lookups = append(lookups, len(items))
+		items = append(items, batchItem{Cep: code})
+	}
+	if len(problems) > 0 {
+		writeFieldErrors(w, r, errCodeInvalidCEP, problems)
+		return
+	}
+
+	for range s.resolveItems(r, items, lookups) {
+		// The array keeps the list order, so wait for everything.
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(batchStatus(items))
+	json.NewEncoder(w).Encode(items)
+}
+
+// resolveItems looks up the items at the indexes in lookups with up to
+// batchConcurrency workers, sending each index on the returned channel as
+// its item is filled in; the channel is closed once all are.
+func (s *server) resolveItems(r *http.Request, items []batchItem, lookups []int) <-chan int {
+	return s.concurrently(lookups, func(i int) {
+		items[i] = s.batchLookup(r.Context(), r, items[i].Cep)
+	})
+}
+
+// concurrently calls f on each of indexes with up to batchConcurrency
+// workers, sending each index on the returned channel once f returns for
+// it; the channel is closed once all have.
+func (s *server) concurrently(indexes []int, f func(i int)) <-chan int {
+	done := make(chan int)
+	pending := make(chan int)
+	var wg sync.WaitGroup
+	for range min(s.batchConcurrency, len(indexes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				f(i)
+				done <- i
+			}
+		}()
+	}
+	go func() {
+		for _, i := range indexes {
+			pending <- i
+		}
+		close(pending)
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// batchStatus is 207 when any item didn't succeed, telling clients to look
+// at each item's status, and 200 otherwise.
+func batchStatus(items []batchItem) int {
+	for _, item := range items {
+		if item.Status != itemOK {
+			return http.StatusMultiStatus
+		}
+	}
+	return http.StatusOK
+}
+
+// readCEPList decodes a request body holding a JSON array of at most
+// batchMax CEPs, answering the error itself when it can't. The body is
+// expected to be capped with limitBody, so a huge one fails as 413 too.
+func (s *server) readCEPList(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var ceps []string
+	if err := json.NewDecoder(r.Body).Decode(&ceps); err != nil {
+		writeBodyError(w, r, err, "Corpo inválido: esperado um array JSON de CEPs")
+		return nil, false
+	}
+	if len(ceps) > s.batchMax {
+		writeError(w, r, http.StatusRequestEntityTooLarge, errCodeBatchTooLarge, fmt.Sprintf("Lote excede o limite de %d CEPs", s.batchMax))
+		return nil, false
+	}
+	return ceps, true
+}
+
+// batchLookup resolves one normalized CEP of a batch into its item,
+// recording successes in the history on behalf of r.
+func (s *server) batchLookup(ctx context.Context, r *http.Request, code string) batchItem {
+	start := time.Now()
+	result := s.lookup(ctx, code)
+	shown := presentCEP(r, code)
+	if result.Err != nil {
+		return batchItem{Cep: shown, Status: itemStatus(result.Err), Erro: result.Err.Error()}
+	}
+	s.recordHistory(r, code, result, time.Since(start))
+	result.Data.Cep = shown
+	return batchItem{Cep: shown, Status: itemOK, Origem: result.Origem, Data: &result.Data, Provenance: result.Provenance, DurationMs: result.DurationMs, CachedAt: result.CachedAt}
+}
+
+func invalidItem(raw string, err error) batchItem {
+	return batchItem{Cep: raw, Status: itemError, Erro: err.Error()}
+}
+
+func itemStatus(err error) string {
+	switch {
+	case errors.Is(err, cep.ErrNotFound):
+		return itemNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return itemTimeout
+	default:
+		return itemError
+	}
+}
+
+var batchCSVHeader = []string{"cep", "state", "city", "neighborhood", "street", "source", "error", "status"}
+
+func (it batchItem) csvRecord() []string {
+	if it.Data == nil {
+		return []string{it.Cep, "", "", "", "", "", it.Erro, it.Status}
+	}
+	d := it.Data
+	return []string{it.Cep, d.State, d.City, d.Neighborhood, d.Street, d.Source, it.Erro, it.Status}
+}
+
+// accepts reports whether the Accept header explicitly asks for mediaType.
+func accepts(accept, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		t, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || t != mediaType {
+			continue
+		}
+		if v, ok := params["q"]; ok {
+			q, err := strconv.ParseFloat(v, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
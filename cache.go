@@ -0,0 +1,153 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// addressCache stores resolved addresses by normalized CEP. Backends treat
+// their own failures as misses: a broken cache slows lookups down, it
+// doesn't fail them. Only the admin operations Delete and Clear report
+// errors.
+type addressCache interface {
+	Get(ctx context.Context, code string) (cep.Address, bool)
+	// Stale returns the entry for code even past its TTL, with when it was
+	// stored, as long as the backend still holds it.
+	Stale(ctx context.Context, code string) (address cep.Address, stored time.Time, ok bool)
+	Set(ctx context.Context, code string, address cep.Address, ttl time.Duration)
+	Delete(ctx context.Context, code string) error
+	Clear(ctx context.Context) error
+}
+
+type cacheEntry struct {
+	cep     string
+	address cep.Address
+	stored  time.Time
+	expires time.Time
+}
+
+// memoryCache is an in-process TTL cache bounded to maxSize entries. When
+// full it evicts the least recently used entry, so popular CEPs stay in
+// while one-off lookups make way. Expired entries count as misses but are
+// kept until evicted or replaced, for Stale.
+// It counts hits, misses and evictions, for /stats and /metrics, to help
+// size it for the real traffic.
+type memoryCache struct {
+	mu      sync.Mutex
+	maxSize int
+	entries map[string]*list.Element
+	// order has the most recently used entry at the front.
+	order *list.List
+
+	hits, misses, evictions int64
+}
+
+type cacheStats struct {
+	Entries    int     `json:"entries"`
+	MaxEntries int     `json:"max_entries"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	Evictions  int64   `json:"evictions"`
+	HitRatio   float64 `json:"hit_ratio"`
+}
+
+func newMemoryCache(maxSize int) *memoryCache {
+	return &memoryCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *memoryCache) Get(_ context.Context, code string) (cep.Address, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[code]
+	if !ok {
+		c.miss()
+		return cep.Address{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.miss()
+		return cep.Address{}, false
+	}
+	c.order.MoveToFront(elem)
+	c.hits++
+	cacheHits.Inc()
+	return entry.address, true
+}
+
+func (c *memoryCache) Stale(_ context.Context, code string) (cep.Address, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[code]
+	if !ok {
+		return cep.Address{}, time.Time{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	return entry.address, entry.stored, true
+}
+
+func (c *memoryCache) miss() {
+	c.misses++
+	cacheMisses.Inc()
+}
+
+func (c *memoryCache) Set(_ context.Context, code string, address cep.Address, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[code]; ok {
+		c.remove(elem)
+	}
+	now := time.Now()
+	entry := &cacheEntry{cep: code, address: address, stored: now, expires: now.Add(ttl)}
+	c.entries[code] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxSize {
+		c.remove(c.order.Back())
+		c.evictions++
+		cacheEvictions.Inc()
+	}
+}
+
+func (c *memoryCache) Delete(_ context.Context, code string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[code]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+func (c *memoryCache) Clear(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.order.Init()
+	return nil
+}
+
+func (c *memoryCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := cacheStats{Entries: c.order.Len(), MaxEntries: c.maxSize, Hits: c.hits, Misses: c.misses, Evictions: c.evictions}
+	if total := c.hits + c.misses; total > 0 {
+		s.HitRatio = float64(c.hits) / float64(total)
+	}
+	return s
+}
+
+func (c *memoryCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).cep)
+}
@@ -0,0 +1,138 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a provider's circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is a circuit breaker for one provider. It opens after threshold
+// consecutive failures, rejects calls for cooldown, then lets a single probe
+// through (half-open) to decide whether to close again. A nil *Breaker
+// always allows calls.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	// OnStateChange, if set, is called with the new state on every
+	// transition, while the breaker's lock is held.
+	OnStateChange func(BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker returns a closed breaker that opens after threshold consecutive
+// failures and stays open for cooldown.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// State returns the breaker's current state.
+func (b *Breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record feeds the outcome of an allowed call back into the breaker. A
+// not-found answer counts as success. A call cut short, whether another
+// provider already won (ErrLostRace) or the caller gave up first, says
+// nothing about the provider's health; both only free the probe slot, the
+// first wrapping context.Canceled as well.
+func (b *Breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case err == nil || errors.Is(err, ErrNotFound):
+		b.failures = 0
+		b.probing = false
+		b.setState(BreakerClosed)
+	case errors.Is(err, context.Canceled):
+		b.probing = false
+	default:
+		b.failures++
+		b.probing = false
+		if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+			b.openedAt = time.Now()
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// skip gives back a half-open probe slot for a call that was allowed but
+// never reached the provider.
+func (b *Breaker) skip() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(state)
+	}
+}
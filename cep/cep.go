@@ -0,0 +1,133 @@
+// Package cep resolves Brazilian postal codes (CEPs) by racing several
+// public providers and returning the first good answer.
+package cep
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Address is the normalized address returned regardless of which provider
+// answered.
+type Address struct {
+	Cep          string `json:"cep" xml:"cep"`
+	State        string `json:"state" xml:"state"`
+	City         string `json:"city" xml:"city"`
+	Neighborhood string `json:"neighborhood" xml:"neighborhood"`
+	Street       string `json:"street" xml:"street"`
+	Source       string `json:"source" xml:"source"`
+	// Lat and Lng are only set when the provider supplies coordinates.
+	Lat *float64 `json:"lat,omitempty" xml:"lat,omitempty"`
+	Lng *float64 `json:"lng,omitempty" xml:"lng,omitempty"`
+}
+
+// ErrNotFound is returned by providers when the CEP does not exist.
+var ErrNotFound = errors.New("CEP não encontrado")
+
+// ErrNoProviders is returned when every provider is being skipped, e.g.
+// because all their circuit breakers are open.
+var ErrNoProviders = errors.New("nenhum provedor disponível")
+
+// ErrLostRace is the cause a Resolver cancels its remaining provider calls
+// with once the race is settled. Their errors wrap it along with
+// context.Canceled, so they can be told apart from genuine failures and
+// from a caller giving up.
+var ErrLostRace = errors.New("consulta cancelada: a disputa já foi decidida")
+
+// ErrIncomplete is wrapped by providers whose answer decoded fine but lacks
+// fields every real address has, which usually means the upstream changed
+// its response format.
+var ErrIncomplete = errors.New("resposta incompleta do provedor")
+
+// ErrImpossible is wrapped by Normalize for well-formed CEPs that cannot
+// exist, so callers can tell them apart from malformed input.
+var ErrImpossible = errors.New("CEP fora das faixas existentes")
+
+// Failure records one provider's error; an empty Provider marks the lookup
+// deadline expiring before the remaining providers answered.
+type Failure struct {
+	Provider string
+	Err      error
+}
+
+// LookupError reports why every provider failed a lookup. It unwraps to
+// each provider's error, so errors.Is matches ErrNotFound or
+// context.DeadlineExceeded if any provider returned them.
+type LookupError struct {
+	Failures []Failure
+}
+
+func (e *LookupError) Error() string {
+	return "todos os provedores falharam: " + e.Detail()
+}
+
+// Detail lists the failures, each prefixed with its provider, without
+// Error's fixed lead-in.
+func (e *LookupError) Detail() string {
+	parts := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		if f.Provider == "" {
+			parts[i] = f.Err.Error()
+			continue
+		}
+		parts[i] = f.Provider + ": " + f.Err.Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (e *LookupError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// Normalize accepts both 12345678 and 12345-678, ignoring surrounding or
+// embedded whitespace, and returns the bare 8-digit form. The error for a
+// malformed CEP tells non-digits, too few and too many digits apart.
+// Well-formed CEPs that can never resolve fail with an error wrapping
+// ErrImpossible; see impossible for the patterns rejected.
+func Normalize(raw string) (string, error) {
+	cep := strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, raw)
+
+	for _, r := range cep {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("CEP inválido %q: contém caracteres que não são dígitos; use 12345678 ou 12345-678", raw)
+		}
+	}
+	switch {
+	case len(cep) < 8:
+		return "", fmt.Errorf("CEP inválido %q: curto demais, tem %d dígitos em vez de 8; use 12345678 ou 12345-678", raw, len(cep))
+	case len(cep) > 8:
+		return "", fmt.Errorf("CEP inválido %q: longo demais, tem %d dígitos em vez de 8; use 12345678 ou 12345-678", raw, len(cep))
+	}
+	if impossible(cep) {
+		return "", fmt.Errorf("CEP %q: %w", raw, ErrImpossible)
+	}
+	return cep, nil
+}
+
+// Format presents a normalized CEP the way it is written in Brazil,
+// 12345-678.
+func Format(cep string) string {
+	if len(cep) != 8 {
+		return cep
+	}
+	return cep[:5] + "-" + cep[5:]
+}
+
+// impossible reports CEPs that no provider will ever know. The list is kept
+// short on purpose, to patterns that can't be real:
+//   - anything starting with 00, the all-zero placeholder 00000000
+//     included: the lowest CEP range, São Paulo's, starts at 01000-000.
+func impossible(cep string) bool {
+	return strings.HasPrefix(cep, "00")
+}
@@ -0,0 +1,131 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Clock is the time source behind a Resolver's deadline, Primary's head
+// start, the preference and completeness windows, the durations reported
+// for each provider and the backoff of WithRetry and WithRetryOnEmpty. The
+// real clock is used unless one is given, so a test can pass its own to
+// expire a lookup or skip a backoff right away instead of sleeping through
+// it.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has passed on the
+	// clock. stop cancels the call, reporting false if it already ran.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+type clockKey struct{}
+
+// withClock makes c the clock of the lookups under ctx; nil keeps the real
+// one.
+func withClock(ctx context.Context, c Clock) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+func clockOf(ctx context.Context) Clock {
+	if c, ok := ctx.Value(clockKey{}).(Clock); ok {
+		return c
+	}
+	return systemClock{}
+}
+
+// withClockTimeout is context.WithTimeout on ctx's clock. With the real
+// clock it is exactly that; otherwise ctx is cancelled when c says d has
+// passed, and reports context.DeadlineExceeded like a real deadline does.
+func withClockTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clockOf(ctx).(systemClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	ctx, cancel := withClockTimeoutCause(ctx, d)
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// withClockTimeoutCause is withClockTimeout with a cancel that records why,
+// for context.Cause. The cause is set on the context that also carries the
+// deadline, not on a child of it: a fake deadline shows as
+// context.DeadlineExceeded in Err only on that context, its children just
+// report context.Canceled.
+func withClockTimeoutCause(ctx context.Context, d time.Duration) (context.Context, context.CancelCauseFunc) {
+	c := clockOf(ctx)
+	if _, ok := c.(systemClock); ok {
+		ctx, cancelCause := context.WithCancelCause(ctx)
+		ctx, cancel := context.WithTimeout(ctx, d)
+		return ctx, func(cause error) {
+			cancelCause(cause)
+			cancel()
+		}
+	}
+	inner, cancelCause := context.WithCancelCause(ctx)
+	stop := c.AfterFunc(d, func() { cancelCause(context.DeadlineExceeded) })
+	return clockContext{Context: inner, deadline: c.Now().Add(d)}, func(cause error) {
+		stop()
+		cancelCause(cause)
+	}
+}
+
+// clockContext carries a deadline measured on a Clock other than the real
+// one.
+type clockContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c clockContext) Deadline() (time.Time, bool) {
+	if parent, ok := c.Context.Deadline(); ok && parent.Before(c.deadline) {
+		return parent, true
+	}
+	return c.deadline, true
+}
+
+func (c clockContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// timeLeft is how long ctx's deadline is from now on its clock; ok is
+// false without a deadline.
+func timeLeft(ctx context.Context) (left time.Duration, ok bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return deadline.Sub(clockOf(ctx).Now()), true
+}
+
+// after is time.After on ctx's clock; stop releases the timer when the
+// channel is no longer waited on.
+func after(ctx context.Context, d time.Duration) (c <-chan struct{}, stop func() bool) {
+	done := make(chan struct{})
+	return done, clockOf(ctx).AfterFunc(d, func() { close(done) })
+}
+
+// sleep waits d on ctx's clock, returning false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	done, stop := after(ctx, d)
+	select {
+	case <-ctx.Done():
+		stop()
+		return false
+	case <-done:
+		return true
+	}
+}
@@ -0,0 +1,198 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at   time.Time
+	f    func()
+	done bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		stopped := !t.done
+		t.done = true
+		return stopped
+	}
+}
+
+// Advance moves the clock by d and runs the timers that became due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []func()
+	for _, t := range c.timers {
+		if !t.done && !t.at.After(c.now) {
+			t.done = true
+			due = append(due, t.f)
+		}
+	}
+	c.mu.Unlock()
+	for _, f := range due {
+		go f()
+	}
+}
+
+// waitTimers blocks until n timers are pending, so Advance doesn't run
+// ahead of the code under test.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		pending := 0
+		for _, timer := range c.timers {
+			if !timer.done {
+				pending++
+			}
+		}
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// funcProvider is a Provider whose lookup is the function given.
+type funcProvider struct {
+	name   string
+	lookup func(ctx context.Context, cep string) (Address, error)
+}
+
+func (p funcProvider) Name() string                   { return p.name }
+func (p funcProvider) Ping(ctx context.Context) error { return nil }
+func (p funcProvider) Lookup(ctx context.Context, cep string) (Address, error) {
+	return p.lookup(ctx, cep)
+}
+
+// blockingProvider answers only once ctx is done, signalling started when
+// the lookup begins.
+func blockingProvider(name string, started chan<- string) funcProvider {
+	return funcProvider{name: name, lookup: func(ctx context.Context, cep string) (Address, error) {
+		started <- name
+		<-ctx.Done()
+		return Address{}, ctx.Err()
+	}}
+}
+
+func TestResolveFakeClockTimeout(t *testing.T) {
+	clock := newFakeClock()
+	started := make(chan string, 2)
+	done := make(chan error, 2)
+	r := &Resolver{
+		Providers: []Provider{blockingProvider("a", started), blockingProvider("b", started)},
+		Timeout:   time.Minute,
+		Clock:     clock,
+		Hooks: Hooks{ProviderDone: func(provider string, d time.Duration, err error) {
+			done <- err
+		}},
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.Resolve(context.Background(), "01001000")
+		errc <- err
+	}()
+	<-started
+	<-started
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute)
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Resolve didn't return once the fake deadline passed")
+	}
+	var lookupErr *LookupError
+	if !errors.As(err, &lookupErr) {
+		t.Fatalf("err = %v, want a *LookupError", err)
+	}
+	for _, f := range lookupErr.Failures {
+		if !errors.Is(f.Err, context.DeadlineExceeded) {
+			t.Errorf("failure of %q = %v, want context.DeadlineExceeded", f.Provider, f.Err)
+		}
+	}
+	for range 2 {
+		if err := <-done; !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrLostRace) {
+			t.Errorf("provider error = %v, want context.DeadlineExceeded", err)
+		}
+	}
+}
+
+func TestResolveFakeClockHeadStart(t *testing.T) {
+	clock := newFakeClock()
+	started := make(chan string, 2)
+	fast := funcProvider{name: "b", lookup: func(ctx context.Context, cep string) (Address, error) {
+		started <- "b"
+		return Address{Cep: cep, State: "SP", City: "São Paulo", Source: "b"}, nil
+	}}
+	r := &Resolver{
+		Providers: []Provider{blockingProvider("a", started), fast},
+		Primary:   "a",
+		HeadStart: time.Hour,
+		Clock:     clock,
+	}
+
+	type outcome struct {
+		result Result
+		err    error
+	}
+	out := make(chan outcome, 1)
+	go func() {
+		result, err := r.Resolve(context.Background(), "01001000")
+		out <- outcome{result, err}
+	}()
+	if name := <-started; name != "a" {
+		t.Fatalf("%s started first, want the primary", name)
+	}
+	clock.waitTimers(t, 1)
+	select {
+	case name := <-started:
+		t.Fatalf("%s started before the head start ended", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Hour)
+
+	select {
+	case o := <-out:
+		if o.err != nil || o.result.Provider != "b" {
+			t.Fatalf("Resolve = %v, %v, want b's answer", o.result.Provider, o.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Resolve didn't launch the others once the fake head start ended")
+	}
+}
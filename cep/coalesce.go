@@ -0,0 +1,83 @@
+package cep
+
+import (
+	"context"
+	"sync"
+)
+
+type coalesceProvider struct {
+	Provider
+	mu    *sync.Mutex
+	calls map[string]*call
+}
+
+// call is one outbound lookup shared by every concurrent caller asking the
+// same provider for the same CEP.
+type call struct {
+	done    chan struct{}
+	address Address
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Coalesce wraps p so that concurrent lookups of the same CEP share one
+// call to p instead of each making its own. The shared call is detached
+// from the callers' deadlines and is cancelled once every caller has given
+// up, so a caller leaving early doesn't fail the others. Lookups that want
+// the raw body (WithRaw) always make their own call.
+func Coalesce(p Provider) Provider {
+	return coalesceProvider{Provider: p, mu: new(sync.Mutex), calls: make(map[string]*call)}
+}
+
+func (p coalesceProvider) Lookup(ctx context.Context, cep string) (Address, error) {
+	if wantsRaw(ctx) {
+		return p.Provider.Lookup(ctx, cep)
+	}
+
+	p.mu.Lock()
+	c, ok := p.calls[cep]
+	if !ok {
+		c = p.start(ctx, cep)
+	}
+	c.waiters++
+	p.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.address, c.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			p.forget(cep, c)
+		}
+		p.mu.Unlock()
+		return Address{}, ctx.Err()
+	}
+}
+
+// start launches the shared call for cep; p.mu must be held.
+func (p coalesceProvider) start(ctx context.Context, cep string) *call {
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &call{done: make(chan struct{}), cancel: cancel}
+	p.calls[cep] = c
+	go func() {
+		defer cancel()
+		c.address, c.err = p.Provider.Lookup(callCtx, cep)
+		p.mu.Lock()
+		p.forget(cep, c)
+		p.mu.Unlock()
+		close(c.done)
+	}()
+	return c
+}
+
+// forget removes c unless a newer call for cep already replaced it; p.mu
+// must be held.
+func (p coalesceProvider) forget(cep string, c *call) {
+	if p.calls[cep] == c {
+		delete(p.calls, cep)
+	}
+}
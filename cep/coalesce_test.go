@@ -0,0 +1,65 @@
+package cep
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesceSharesOneCall(t *testing.T) {
+	const n = 50
+	var hits atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(viaCepBody))
+	}))
+	defer srv.Close()
+
+	p := Coalesce(ViaCep{Client: srv.Client(), BaseURL: srv.URL})
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Lookup(context.Background(), "01001000")
+			errs <- err
+		}()
+	}
+
+	// Hold the upstream answer until every lookup has joined the call.
+	cp := p.(coalesceProvider)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cp.mu.Lock()
+		c := cp.calls["01001000"]
+		joined := c != nil && c.waiters == n
+		cp.mu.Unlock()
+		if joined {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lookups didn't all join the shared call")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("upstream got %d requests, want 1", got)
+	}
+}
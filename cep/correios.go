@@ -0,0 +1,200 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCorreiosURL is the base URL of the official Correios API.
+const DefaultCorreiosURL = "https://api.correios.com.br"
+
+const (
+	// correiosTokenTimeout bounds a token request. It runs apart from the
+	// lookup that triggered it, so a slow authentication still yields a
+	// token for the lookups that follow.
+	correiosTokenTimeout = 10 * time.Second
+	// correiosTokenMargin renews the token this long before it expires.
+	correiosTokenMargin = time.Minute
+	// correiosTokenTTL is assumed when the expiry in the token response
+	// can't be parsed.
+	correiosTokenTTL = time.Hour
+)
+
+// Correios queries the official Correios API, which requires credentials
+// from the Correios portal: every lookup carries a bearer token obtained
+// with Username and AccessCode and renewed before it expires. A nil Client
+// means http.DefaultClient, an empty BaseURL means DefaultCorreiosURL and
+// an empty UserAgent means DefaultUserAgent.
+//
+// A Correios must not be copied after first use.
+type Correios struct {
+	Client    *http.Client
+	BaseURL   string
+	UserAgent string
+	// MaxBodySize caps the response read; zero means DefaultMaxBodySize.
+	MaxBodySize int64
+
+	// Username is the portal user and AccessCode the API access code
+	// generated for it.
+	Username   string
+	AccessCode string
+	// PostingCard, when set, authenticates against that posting card
+	// (cartão de postagem) of the user's contract.
+	PostingCard string
+
+	flights singleflight.Group
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type correiosToken struct {
+	Token      string `json:"token"`
+	ExpiraEm   string `json:"expiraEm"`
+	ZoneOffset string `json:"zoneOffset"`
+}
+
+// expiry reads expiraEm, a local time without offset, in the zone given
+// alongside it.
+func (t correiosToken) expiry(now time.Time) time.Time {
+	offset := t.ZoneOffset
+	if offset == "" || offset == "Z" {
+		offset = "-03:00"
+	}
+	expires, err := time.Parse("2006-01-02T15:04:05-07:00", t.ExpiraEm+offset)
+	if err != nil {
+		return now.Add(correiosTokenTTL)
+	}
+	return expires
+}
+
+type correiosAddress struct {
+	Cep            string `json:"cep"`
+	UF             string `json:"uf"`
+	Localidade     string `json:"localidade"`
+	Bairro         string `json:"bairro"`
+	Logradouro     string `json:"logradouro"`
+	TipoLogradouro string `json:"tipoLogradouro"`
+	NomeLogradouro string `json:"nomeLogradouro"`
+}
+
+func (a correiosAddress) toAddress() Address {
+	street := a.Logradouro
+	if street == "" {
+		street = strings.TrimSpace(a.TipoLogradouro + " " + a.NomeLogradouro)
+	}
+	return Address{
+		Cep:          a.Cep,
+		State:        a.UF,
+		City:         a.Localidade,
+		Neighborhood: a.Bairro,
+		Street:       street,
+		Source:       "correios",
+	}
+}
+
+func (p *Correios) Name() string { return "correios" }
+
+func (p *Correios) url(cep string) string {
+	return baseURLOr(p.BaseURL, DefaultCorreiosURL) + fmt.Sprintf("/cep/v2/enderecos/%s", cep)
+}
+
+func (p *Correios) Ping(ctx context.Context) error {
+	return ping(ctx, p.Client, p.UserAgent, p.url(pingCEP))
+}
+
+// Lookup fails like any other provider when no token can be obtained, so
+// the free providers still answer the race.
+func (p *Correios) Lookup(ctx context.Context, cep string) (Address, error) {
+	token, err := p.bearer(ctx)
+	if err != nil {
+		return Address{}, fmt.Errorf("autenticação nos Correios: %w", err)
+	}
+	req, err := newRequest(ctx, http.MethodGet, p.UserAgent, p.url(cep), nil)
+	if err != nil {
+		return Address{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var address correiosAddress
+	if err := doJSON(p.Client, req, p.MaxBodySize, false, &address); err != nil {
+		var se *StatusError
+		if errors.As(err, &se) && se.Code == http.StatusUnauthorized {
+			p.forget(token)
+		}
+		return Address{}, err
+	}
+	return checked(cep, address.toAddress())
+}
+
+// bearer returns the current token, authenticating when there is none or
+// it is about to expire. Concurrent lookups share one authentication.
+func (p *Correios) bearer(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	token, expires := p.token, p.expires
+	p.mu.Unlock()
+	if token != "" && time.Now().Before(expires.Add(-correiosTokenMargin)) {
+		return token, nil
+	}
+
+	ch := p.flights.DoChan("token", func() (any, error) {
+		ctx, cancel := context.WithTimeout(withoutRaw(context.WithoutCancel(ctx)), correiosTokenTimeout)
+		defer cancel()
+		return p.authenticate(ctx)
+	})
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	}
+}
+
+func (p *Correios) authenticate(ctx context.Context) (string, error) {
+	u := baseURLOr(p.BaseURL, DefaultCorreiosURL) + "/token/v1/autentica"
+	var req *http.Request
+	var err error
+	if p.PostingCard != "" {
+		body := fmt.Sprintf(`{"numero":%q}`, p.PostingCard)
+		req, err = newRequest(ctx, http.MethodPost, p.UserAgent, u+"/cartaopostagem", strings.NewReader(body))
+	} else {
+		req, err = newRequest(ctx, http.MethodPost, p.UserAgent, u, nil)
+	}
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(p.Username, p.AccessCode)
+
+	var t correiosToken
+	if err := doJSON(p.Client, req, p.MaxBodySize, false, &t); err != nil {
+		return "", err
+	}
+	if t.Token == "" {
+		return "", fmt.Errorf("%w: token ausente", ErrIncomplete)
+	}
+	p.mu.Lock()
+	p.token, p.expires = t.Token, t.expiry(time.Now())
+	p.mu.Unlock()
+	return t.Token, nil
+}
+
+// forget drops a token the API rejected so the next lookup authenticates
+// again, unless another lookup already replaced it.
+func (p *Correios) forget(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == token {
+		p.token = ""
+	}
+}
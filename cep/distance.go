@@ -0,0 +1,24 @@
+package cep
+
+import "math"
+
+// earthRadiusKm is the mean Earth radius used by Distance.
+const earthRadiusKm = 6371.0
+
+// Distance returns the great-circle distance in kilometres between two
+// addresses, using the haversine formula. ok is false when either address
+// lacks coordinates.
+func Distance(a, b Address) (km float64, ok bool) {
+	if a.Lat == nil || a.Lng == nil || b.Lat == nil || b.Lng == nil {
+		return 0, false
+	}
+	lat1, lat2 := radians(*a.Lat), radians(*b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(*b.Lng - *a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h)), true
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
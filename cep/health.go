@@ -0,0 +1,103 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+)
+
+// Health scores each provider by its recent calls and skips the flaky ones
+// in proportion to how flaky they are, saving calls a circuit breaker
+// would still make to a provider that fails every other time. The score is
+// the success rate over the last window calls, recent calls weighing
+// more. A provider at or above threshold always races; one below races
+// with a probability equal to its score, never less than minProbe, so it
+// keeps being probed and can recover. A nil *Health races every provider.
+type Health struct {
+	window    int
+	threshold float64
+	minProbe  float64
+
+	mu    sync.Mutex
+	calls map[string][]bool
+}
+
+// NewHealth returns a health score over the last window calls of each
+// provider, skipping those scoring below threshold down to minProbe.
+func NewHealth(window int, threshold, minProbe float64) *Health {
+	return &Health{window: window, threshold: threshold, minProbe: minProbe, calls: make(map[string][]bool)}
+}
+
+// Scores returns each provider's current score, between 0 and 1. Providers
+// without calls yet are absent; they count as healthy.
+func (h *Health) Scores() map[string]float64 {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	scores := make(map[string]float64, len(h.calls))
+	for name := range h.calls {
+		scores[name] = h.score(name)
+	}
+	return scores
+}
+
+// score weighs the i-th oldest call in the window by i+1. Callers hold
+// h.mu.
+func (h *Health) score(provider string) float64 {
+	calls := h.calls[provider]
+	if len(calls) == 0 {
+		return 1
+	}
+	var ok, total float64
+	for i, success := range calls {
+		weight := float64(i + 1)
+		total += weight
+		if success {
+			ok += weight
+		}
+	}
+	return ok / total
+}
+
+// split divides providers into the ones to race and the ones skipped this
+// time, which the Resolver keeps in reserve for when the others fail. It
+// never skips them all.
+func (h *Health) split(providers []Provider) (race, skipped []Provider) {
+	if h == nil {
+		return providers, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range providers {
+		score := h.score(p.Name())
+		if score >= h.threshold || rand.Float64() < max(score, h.minProbe) {
+			race = append(race, p)
+		} else {
+			skipped = append(skipped, p)
+		}
+	}
+	if len(race) == 0 {
+		return providers, nil
+	}
+	return race, skipped
+}
+
+// observe records a provider call; a not-found answer is a success. Calls
+// cut short say nothing about the provider's health, whether another
+// provider won (ErrLostRace) or the caller gave up first: both are left
+// out, the first wrapping context.Canceled as well.
+func (h *Health) observe(provider string, err error) {
+	if h == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	calls := append(h.calls[provider], err == nil || errors.Is(err, ErrNotFound))
+	if len(calls) > h.window {
+		calls = calls[1:]
+	}
+	h.calls[provider] = calls
+}
@@ -0,0 +1,75 @@
+package cep
+
+import "slices"
+
+// Merged is an address assembled from several providers' answers.
+type Merged struct {
+	Address Address
+	// Sources maps each filled field (cep, state, city, neighborhood,
+	// street, lat, lng) to the provider it was taken from.
+	Sources map[string]string
+}
+
+type mergedField struct {
+	name string
+	has  func(*Address) bool
+	take func(dst, src *Address)
+}
+
+var mergedFields = []mergedField{
+	{"cep", func(a *Address) bool { return a.Cep != "" }, func(d, s *Address) { d.Cep = s.Cep }},
+	{"state", func(a *Address) bool { return a.State != "" }, func(d, s *Address) { d.State = s.State }},
+	{"city", func(a *Address) bool { return a.City != "" }, func(d, s *Address) { d.City = s.City }},
+	{"neighborhood", func(a *Address) bool { return a.Neighborhood != "" }, func(d, s *Address) { d.Neighborhood = s.Neighborhood }},
+	{"street", func(a *Address) bool { return a.Street != "" }, func(d, s *Address) { d.Street = s.Street }},
+	{"lat", func(a *Address) bool { return a.Lat != nil }, func(d, s *Address) { d.Lat = s.Lat }},
+	{"lng", func(a *Address) bool { return a.Lng != nil }, func(d, s *Address) { d.Lng = s.Lng }},
+}
+
+// ValidField reports whether name is an Address field as Merged.Sources
+// and Resolver.Required name them.
+func ValidField(name string) bool {
+	for _, f := range mergedFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// missing counts how many of the named fields a leaves empty.
+func missing(a *Address, names []string) int {
+	n := 0
+	for _, f := range mergedFields {
+		if !f.has(a) && slices.Contains(names, f.name) {
+			n++
+		}
+	}
+	return n
+}
+
+// Merge combines the successful results, in the order given, into the most
+// complete address: the first success is the base and each field it left
+// empty is taken from the first later result that has it. ok is false when
+// no result succeeded. The merged Source is the base's provider.
+func Merge(results []Result) (m Merged, ok bool) {
+	m.Sources = make(map[string]string, len(mergedFields))
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		if !ok {
+			m.Address = Address{Source: result.Address.Source}
+			ok = true
+		}
+		src := result.Address
+		for _, f := range mergedFields {
+			if _, done := m.Sources[f.name]; done || !f.has(&src) {
+				continue
+			}
+			f.take(&m.Address, &src)
+			m.Sources[f.name] = result.Provider
+		}
+	}
+	return m, ok
+}
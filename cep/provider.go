@@ -0,0 +1,515 @@
+package cep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Provider is an upstream CEP source taking part in the race.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, cep string) (Address, error)
+	// Ping checks that the upstream is reachable without doing a lookup.
+	Ping(ctx context.Context) error
+}
+
+// Default base URLs of the public providers.
+const (
+	DefaultBrasilAPIURL = "https://brasilapi.com.br"
+	DefaultViaCepURL    = "https://viacep.com.br"
+	DefaultOpenCepURL   = "https://opencep.com"
+	DefaultPostmonURL   = "https://api.postmon.com.br"
+)
+
+// DefaultProviders returns BrasilAPI, ViaCep, OpenCEP and Postmon at their
+// public URLs, all sharing client.
+func DefaultProviders(client *http.Client) []Provider {
+	return []Provider{
+		BrasilAPI{Client: client},
+		ViaCep{Client: client},
+		OpenCep{Client: client},
+		Postmon{Client: client},
+	}
+}
+
+// DefaultUserAgent identifies this library to the providers, some of which
+// throttle generic agents like Go's own.
+const DefaultUserAgent = "multithread-cep/1.0"
+
+// DefaultMaxBodySize bounds how much of a provider's response is read.
+// Real answers are a few KB, the largest being a state's city list, so
+// anything bigger is a broken or hostile upstream.
+const DefaultMaxBodySize = 512 << 10
+
+// ErrBodyTooLarge is returned when a response exceeds the provider's
+// MaxBodySize.
+var ErrBodyTooLarge = errors.New("resposta do provedor excede o tamanho máximo")
+
+// ErrUnknownField is wrapped by providers whose Strict field is set when an
+// answer carries a field they don't know, usually the upstream adding one
+// ahead of changing its format, so it's noticed before it breaks lookups.
+// Removed fields are caught by the checks every answer goes through,
+// strict or not.
+var ErrUnknownField = errors.New("campo desconhecido na resposta do provedor")
+
+// pingCEP is a well-known CEP (Praça da Sé, São Paulo) used for
+// connectivity checks against providers.
+const pingCEP = "01001000"
+
+// StatusError is returned when a provider answers with an unexpected HTTP
+// status.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("requisição falhou: %s", e.Status)
+}
+
+// contentTypeSnippet is how much of a non-JSON body ContentTypeError keeps.
+const contentTypeSnippet = 256
+
+// ContentTypeError is returned when a provider answers 2xx with something
+// other than JSON, typically the HTML error page of a proxy or CDN in
+// front of it during an outage.
+type ContentTypeError struct {
+	ContentType string
+	// Body holds the first bytes of the response, for the debug log.
+	Body []byte
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("tipo de conteúdo inesperado do provedor: %s", e.ContentType)
+}
+
+// jsonContentType accepts the JSON media types, text/plain, which some
+// upstreams send JSON as, and a missing Content-Type.
+func jsonContentType(header string) bool {
+	if header == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" ||
+		mediaType == "text/plain" || strings.HasSuffix(mediaType, "+json")
+}
+
+func clientOrDefault(client *http.Client) *http.Client {
+	if client == nil {
+		return http.DefaultClient
+	}
+	return client
+}
+
+func baseURLOr(baseURL, def string) string {
+	if baseURL == "" {
+		return def
+	}
+	return baseURL
+}
+
+// newRequest builds every upstream request, identifying us with userAgent
+// or DefaultUserAgent when it is empty.
+func newRequest(ctx context.Context, method, userAgent, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar requisição: %v", err)
+	}
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// ping sends a HEAD request; any HTTP response means the host is reachable.
+func ping(ctx context.Context, client *http.Client, userAgent, url string) error {
+	req, err := newRequest(ctx, http.MethodHead, userAgent, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := clientOrDefault(client).Do(req)
+	if err != nil {
+		return err
+	}
+	closeBody(resp)
+	return nil
+}
+
+// getJSON fetches url and decodes the response into v as doJSON does.
+func getJSON(ctx context.Context, client *http.Client, userAgent string, maxBody int64, strict bool, url string, v any) error {
+	req, err := newRequest(ctx, http.MethodGet, userAgent, url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(client, req, maxBody, strict, v)
+}
+
+// doJSON sends req and decodes a 2xx response into v. A 404 is reported as
+// ErrNotFound and any other status as a *StatusError; a body that isn't
+// JSON as a *ContentTypeError, and one longer than maxBody
+// (DefaultMaxBodySize when not positive) as ErrBodyTooLarge. When strict, a
+// field v has no place for is reported wrapping ErrUnknownField.
+func doJSON(client *http.Client, req *http.Request, maxBody int64, strict bool, v any) error {
+	resp, err := clientOrDefault(client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	if ct := resp.Header.Get("Content-Type"); !jsonContentType(ct) {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, contentTypeSnippet))
+		return &ContentTypeError{ContentType: ct, Body: snippet}
+	}
+
+	if maxBody <= 0 {
+		maxBody = DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody+1))
+	if err != nil {
+		return fmt.Errorf("erro ao ler resposta: %v", err)
+	}
+	if int64(len(body)) > maxBody {
+		return fmt.Errorf("%w (%d bytes)", ErrBodyTooLarge, maxBody)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("erro ao ler resposta: %v", err)
+	}
+	keepRaw(req.Context(), body)
+	if strict {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("%w: %v", ErrUnknownField, err)
+		}
+	}
+	return nil
+}
+
+// checked guards against schema drift: json.Unmarshal stays silent when
+// fields are renamed, so an answer must echo the CEP asked for and carry at
+// least the state and city to count as valid.
+func checked(cep string, a Address) (Address, error) {
+	var missing []string
+	if strings.ReplaceAll(a.Cep, "-", "") != strings.ReplaceAll(cep, "-", "") {
+		missing = append(missing, "cep")
+	}
+	if a.State == "" {
+		missing = append(missing, "state")
+	}
+	if a.City == "" {
+		missing = append(missing, "city")
+	}
+	if len(missing) > 0 {
+		return Address{}, fmt.Errorf("%w: %s ausente ou divergente", ErrIncomplete, strings.Join(missing, ", "))
+	}
+	return a, nil
+}
+
+// closeBody drains what is left of the body so the connection can go back
+// to the pool, which matters for losers of the race that get cancelled.
+func closeBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+// BrasilAPI queries brasilapi.com.br, whose v2 endpoint also returns
+// coordinates for many CEPs. A nil Client means http.DefaultClient, an
+// empty BaseURL means DefaultBrasilAPIURL and an empty UserAgent means
+// DefaultUserAgent.
+type BrasilAPI struct {
+	Client    *http.Client
+	BaseURL   string
+	UserAgent string
+	// MaxBodySize caps the response read; zero means DefaultMaxBodySize.
+	MaxBodySize int64
+	// Strict fails answers with unknown fields; see ErrUnknownField.
+	Strict bool
+}
+
+type brasilAPIAddress struct {
+	Cep          string `json:"cep"`
+	State        string `json:"state"`
+	City         string `json:"city"`
+	Neighborhood string `json:"neighborhood"`
+	Street       string `json:"street"`
+	Location     struct {
+		Coordinates struct {
+			Latitude  coordinate `json:"latitude"`
+			Longitude coordinate `json:"longitude"`
+		} `json:"coordinates"`
+		Type json.RawMessage `json:"type"`
+	} `json:"location"`
+	Service json.RawMessage `json:"service"`
+}
+
+// coordinate decodes BrasilAPI coordinates, which come as strings but may
+// also be numbers or missing altogether.
+type coordinate struct {
+	value *float64
+}
+
+func (c *coordinate) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("coordenada inválida %s: %v", b, err)
+	}
+	c.value = &v
+	return nil
+}
+
+func (a brasilAPIAddress) toAddress() Address {
+	return Address{
+		Cep:          a.Cep,
+		State:        a.State,
+		City:         a.City,
+		Neighborhood: a.Neighborhood,
+		Street:       a.Street,
+		Source:       "brasilapi",
+		Lat:          a.Location.Coordinates.Latitude.value,
+		Lng:          a.Location.Coordinates.Longitude.value,
+	}
+}
+
+func (p BrasilAPI) Name() string { return "brasilapi" }
+
+func (p BrasilAPI) url(cep string) string {
+	return baseURLOr(p.BaseURL, DefaultBrasilAPIURL) + fmt.Sprintf("/api/cep/v2/%s", cep)
+}
+
+func (p BrasilAPI) Ping(ctx context.Context) error {
+	return ping(ctx, p.Client, p.UserAgent, p.url(pingCEP))
+}
+
+func (p BrasilAPI) Lookup(ctx context.Context, cep string) (Address, error) {
+	var address brasilAPIAddress
+	if err := getJSON(ctx, p.Client, p.UserAgent, p.MaxBodySize, p.Strict, p.url(cep), &address); err != nil {
+		return Address{}, err
+	}
+	return checked(cep, address.toAddress())
+}
+
+// ViaCep queries viacep.com.br. A nil Client means http.DefaultClient, an
+// empty BaseURL means DefaultViaCepURL and an empty UserAgent means
+// DefaultUserAgent.
+type ViaCep struct {
+	Client    *http.Client
+	BaseURL   string
+	UserAgent string
+	// MaxBodySize caps the response read; zero means DefaultMaxBodySize.
+	MaxBodySize int64
+	// Strict fails answers with unknown fields; see ErrUnknownField.
+	Strict bool
+}
+
+type viaCepAddress struct {
+	Cep        string     `json:"cep"`
+	Uf         string     `json:"uf"`
+	Localidade string     `json:"localidade"`
+	Bairro     string     `json:"bairro"`
+	Logradouro string     `json:"logradouro"`
+	Erro       viaCepErro `json:"erro"`
+	viaCepUnused
+}
+
+// viaCepUnused holds the rest of ViaCep's answer, decoded only so a strict
+// lookup knows these fields.
+type viaCepUnused struct {
+	Complemento json.RawMessage `json:"complemento"`
+	Unidade     json.RawMessage `json:"unidade"`
+	Estado      json.RawMessage `json:"estado"`
+	Regiao      json.RawMessage `json:"regiao"`
+	IBGE        json.RawMessage `json:"ibge"`
+	GIA         json.RawMessage `json:"gia"`
+	DDD         json.RawMessage `json:"ddd"`
+	SIAFI       json.RawMessage `json:"siafi"`
+}
+
+// viaCepErro accepts both forms ViaCep has used to flag unknown CEPs:
+// "erro": true and "erro": "true".
+type viaCepErro bool
+
+func (e *viaCepErro) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	*e = viaCepErro(s == "true" || s == `"true"`)
+	return nil
+}
+
+func (a viaCepAddress) toAddress() Address {
+	return Address{
+		Cep:          a.Cep,
+		State:        a.Uf,
+		City:         a.Localidade,
+		Neighborhood: a.Bairro,
+		Street:       a.Logradouro,
+		Source:       "viacep",
+	}
+}
+
+func (p ViaCep) Name() string { return "viacep" }
+
+func (p ViaCep) url(cep string) string {
+	return baseURLOr(p.BaseURL, DefaultViaCepURL) + fmt.Sprintf("/ws/%s/json/", cep)
+}
+
+func (p ViaCep) Ping(ctx context.Context) error {
+	return ping(ctx, p.Client, p.UserAgent, p.url(pingCEP))
+}
+
+func (p ViaCep) Lookup(ctx context.Context, cep string) (Address, error) {
+	var address viaCepAddress
+	if err := getJSON(ctx, p.Client, p.UserAgent, p.MaxBodySize, p.Strict, p.url(cep), &address); err != nil {
+		return Address{}, err
+	}
+	if address.Erro {
+		return Address{}, ErrNotFound
+	}
+	return checked(cep, address.toAddress())
+}
+
+// Search finds the CEPs matching a street in a city using ViaCep's address
+// search. ViaCep requires uf to be a two-letter state code and city and
+// street to have at least three characters; it answers an empty list when
+// nothing matches.
+func (p ViaCep) Search(ctx context.Context, uf, city, street string) ([]Address, error) {
+	u := baseURLOr(p.BaseURL, DefaultViaCepURL) + fmt.Sprintf("/ws/%s/%s/%s/json/",
+		url.PathEscape(uf), url.PathEscape(city), url.PathEscape(street))
+	var found []viaCepAddress
+	if err := getJSON(ctx, p.Client, p.UserAgent, p.MaxBodySize, p.Strict, u, &found); err != nil {
+		return nil, err
+	}
+	addresses := make([]Address, len(found))
+	for i, a := range found {
+		addresses[i] = a.toAddress()
+	}
+	return addresses, nil
+}
+
+// OpenCep queries opencep.com. A nil Client means http.DefaultClient, an
+// empty BaseURL means DefaultOpenCepURL and an empty UserAgent means
+// DefaultUserAgent.
+type OpenCep struct {
+	Client    *http.Client
+	BaseURL   string
+	UserAgent string
+	// MaxBodySize caps the response read; zero means DefaultMaxBodySize.
+	MaxBodySize int64
+	// Strict fails answers with unknown fields; see ErrUnknownField.
+	Strict bool
+}
+
+type openCepAddress struct {
+	Cep        string `json:"cep"`
+	Uf         string `json:"uf"`
+	Localidade string `json:"localidade"`
+	Bairro     string `json:"bairro"`
+	Logradouro string `json:"logradouro"`
+	// Complemento and IBGE are decoded only so a strict lookup knows them.
+	Complemento json.RawMessage `json:"complemento"`
+	IBGE        json.RawMessage `json:"ibge"`
+}
+
+func (a openCepAddress) toAddress() Address {
+	return Address{
+		Cep:          a.Cep,
+		State:        a.Uf,
+		City:         a.Localidade,
+		Neighborhood: a.Bairro,
+		Street:       a.Logradouro,
+		Source:       "opencep",
+	}
+}
+
+func (p OpenCep) Name() string { return "opencep" }
+
+func (p OpenCep) url(cep string) string {
+	return baseURLOr(p.BaseURL, DefaultOpenCepURL) + fmt.Sprintf("/v1/%s", cep)
+}
+
+func (p OpenCep) Ping(ctx context.Context) error {
+	return ping(ctx, p.Client, p.UserAgent, p.url(pingCEP))
+}
+
+func (p OpenCep) Lookup(ctx context.Context, cep string) (Address, error) {
+	var address openCepAddress
+	if err := getJSON(ctx, p.Client, p.UserAgent, p.MaxBodySize, p.Strict, p.url(cep), &address); err != nil {
+		return Address{}, err
+	}
+	return checked(cep, address.toAddress())
+}
+
+// Postmon queries api.postmon.com.br, which answers unknown CEPs with a 404.
+// A nil Client means http.DefaultClient, an empty BaseURL means
+// DefaultPostmonURL and an empty UserAgent means DefaultUserAgent.
+type Postmon struct {
+	Client    *http.Client
+	BaseURL   string
+	UserAgent string
+	// MaxBodySize caps the response read; zero means DefaultMaxBodySize.
+	MaxBodySize int64
+	// Strict fails answers with unknown fields; see ErrUnknownField.
+	Strict bool
+}
+
+type postmonAddress struct {
+	Cep        string `json:"cep"`
+	Estado     string `json:"estado"`
+	Cidade     string `json:"cidade"`
+	Bairro     string `json:"bairro"`
+	Logradouro string `json:"logradouro"`
+	// The rest is decoded only so a strict lookup knows these fields.
+	Complemento json.RawMessage `json:"complemento"`
+	EstadoInfo  json.RawMessage `json:"estado_info"`
+	CidadeInfo  json.RawMessage `json:"cidade_info"`
+}
+
+func (a postmonAddress) toAddress() Address {
+	return Address{
+		Cep:          a.Cep,
+		State:        a.Estado,
+		City:         a.Cidade,
+		Neighborhood: a.Bairro,
+		Street:       a.Logradouro,
+		Source:       "postmon",
+	}
+}
+
+func (p Postmon) Name() string { return "postmon" }
+
+func (p Postmon) url(cep string) string {
+	return baseURLOr(p.BaseURL, DefaultPostmonURL) + fmt.Sprintf("/v1/cep/%s", cep)
+}
+
+func (p Postmon) Ping(ctx context.Context) error {
+	return ping(ctx, p.Client, p.UserAgent, p.url(pingCEP))
+}
+
+// Lookup relies on getJSON mapping Postmon's 404 to ErrNotFound.
+func (p Postmon) Lookup(ctx context.Context, cep string) (Address, error) {
+	var address postmonAddress
+	if err := getJSON(ctx, p.Client, p.UserAgent, p.MaxBodySize, p.Strict, p.url(cep), &address); err != nil {
+		return Address{}, err
+	}
+	return checked(cep, address.toAddress())
+}
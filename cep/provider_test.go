@@ -0,0 +1,160 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var providerCases = []struct {
+	name string
+	new  func(client *http.Client, baseURL string) Provider
+	body string
+}{
+	{
+		name: "brasilapi",
+		new:  func(c *http.Client, u string) Provider { return BrasilAPI{Client: c, BaseURL: u} },
+		body: brasilAPIBody,
+	},
+	{
+		name: "viacep",
+		new:  func(c *http.Client, u string) Provider { return ViaCep{Client: c, BaseURL: u} },
+		body: viaCepBody,
+	},
+	{
+		name: "opencep",
+		new:  func(c *http.Client, u string) Provider { return OpenCep{Client: c, BaseURL: u} },
+		body: `{"cep":"01001-000","logradouro":"Praça da Sé","complemento":"lado ímpar","bairro":"Sé","localidade":"São Paulo","uf":"SP","ibge":"3550308"}`,
+	},
+	{
+		name: "postmon",
+		new:  func(c *http.Client, u string) Provider { return Postmon{Client: c, BaseURL: u} },
+		body: `{"bairro":"Sé","cidade":"São Paulo","logradouro":"Praça da Sé","cep":"01001000","estado":"SP"}`,
+	},
+	{
+		name: "correios",
+		new: func(c *http.Client, u string) Provider {
+			return &Correios{Client: c, BaseURL: u, Username: "user", AccessCode: "code"}
+		},
+		body: `{"cep":"01001000","uf":"SP","localidade":"São Paulo","bairro":"Sé","logradouro":"Praça da Sé"}`,
+	},
+}
+
+func TestProviderLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		// respond answers the lookup, given the provider's success body.
+		respond func(w http.ResponseWriter, r *http.Request, body string)
+		check   func(t *testing.T, a Address, err error)
+	}{
+		{
+			name: "success",
+			respond: func(w http.ResponseWriter, r *http.Request, body string) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body))
+			},
+			check: func(t *testing.T, a Address, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a.State != "SP" || a.City != "São Paulo" || a.Street != "Praça da Sé" {
+					t.Errorf("address = %+v", a)
+				}
+			},
+		},
+		{
+			name: "not found",
+			respond: func(w http.ResponseWriter, r *http.Request, body string) {
+				http.NotFound(w, r)
+			},
+			check: func(t *testing.T, a Address, err error) {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("err = %v, want ErrNotFound", err)
+				}
+			},
+		},
+		{
+			name: "timeout",
+			respond: func(w http.ResponseWriter, r *http.Request, body string) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			check: func(t *testing.T, a Address, err error) {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("err = %v, want context.DeadlineExceeded", err)
+				}
+			},
+		},
+		{
+			name: "malformed JSON",
+			respond: func(w http.ResponseWriter, r *http.Request, body string) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body[:len(body)/2]))
+			},
+			check: func(t *testing.T, a Address, err error) {
+				if err == nil || !strings.Contains(err.Error(), "erro ao ler resposta") {
+					t.Errorf("err = %v, want a decoding error", err)
+				}
+			},
+		},
+	}
+
+	for _, pc := range providerCases {
+		for _, tt := range tests {
+			t.Run(pc.name+"/"+tt.name, func(t *testing.T) {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if strings.HasPrefix(r.URL.Path, "/token/") {
+						w.Header().Set("Content-Type", "application/json")
+						w.Write([]byte(`{"token":"t","expiraEm":"2099-01-01T00:00:00"}`))
+						return
+					}
+					tt.respond(w, r, pc.body)
+				}))
+				defer srv.Close()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+				defer cancel()
+				a, err := pc.new(srv.Client(), srv.URL).Lookup(ctx, "01001000")
+				tt.check(t, a, err)
+				if err == nil && a.Source != pc.name {
+					t.Errorf("source = %q, want %q", a.Source, pc.name)
+				}
+			})
+		}
+	}
+}
+
+func TestViaCepErro(t *testing.T) {
+	for _, body := range []string{`{"erro": true}`, `{"erro": "true"}`} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}))
+		_, err := ViaCep{Client: srv.Client(), BaseURL: srv.URL}.Lookup(context.Background(), "99999999")
+		srv.Close()
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: err = %v, want ErrNotFound", body, err)
+		}
+	}
+}
+
+func TestDoJSONContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>manutenção</html>"))
+	}))
+	defer srv.Close()
+
+	var v any
+	err := getJSON(context.Background(), srv.Client(), "", 0, false, srv.URL, &v)
+	var cte *ContentTypeError
+	if !errors.As(err, &cte) || cte.ContentType != "text/html" {
+		t.Fatalf("err = %v, want a *ContentTypeError", err)
+	}
+}
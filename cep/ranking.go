@@ -0,0 +1,108 @@
+package cep
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// rankingWeight is how much each new sample moves a provider's average.
+	rankingWeight = 0.2
+	// rankingInterval is how often the order is recomputed from the
+	// averages, so it doesn't reshuffle on every sample. A provider's first
+	// sample re-ranks right away.
+	rankingInterval = 10 * time.Second
+	// rankingExploreEvery makes one race in this many include every
+	// provider, so those left out keep getting fresh samples.
+	rankingExploreEvery = 20
+	// rankingFailure is the latency a failed call counts as.
+	rankingFailure = 5 * time.Second
+)
+
+// Ranking limits a Resolver's race to the n providers with the lowest
+// moving average latency, saving the others' quota. Providers without
+// samples rank first so they get measured. A nil *Ranking races every
+// provider.
+type Ranking struct {
+	n int
+
+	mu      sync.Mutex
+	average map[string]time.Duration
+	order   []string
+	ranked  time.Time
+	races   int
+}
+
+// NewRanking returns a ranking that races the n fastest providers.
+func NewRanking(n int) *Ranking {
+	return &Ranking{n: n, average: make(map[string]time.Duration)}
+}
+
+// split divides providers into the ones to race first and the ones kept in
+// reserve for when all of those fail.
+func (k *Ranking) split(providers []Provider) (first, rest []Provider) {
+	if k == nil || k.n >= len(providers) {
+		return providers, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.races++
+	if k.races%rankingExploreEvery == 0 {
+		return providers, nil
+	}
+	now := time.Now()
+	if now.Sub(k.ranked) >= rankingInterval || len(k.order) != len(k.average) {
+		k.order = k.order[:0]
+		for name := range k.average {
+			k.order = append(k.order, name)
+		}
+		slices.SortFunc(k.order, func(a, b string) int {
+			return cmp.Compare(k.average[a], k.average[b])
+		})
+		k.ranked = now
+	}
+
+	rank := func(p Provider) int {
+		if i := slices.Index(k.order, p.Name()); i >= 0 {
+			return i + 1
+		}
+		return 0
+	}
+	sorted := slices.Clone(providers)
+	slices.SortStableFunc(sorted, func(a, b Provider) int { return rank(a) - rank(b) })
+	return sorted[:k.n], sorted[k.n:]
+}
+
+// observe feeds a provider call into its average. A call cancelled because
+// another provider won only shows the provider is at least that slow, so
+// it can raise the average but never lower it. One whose caller gave up
+// first shows nothing and is left out.
+func (k *Ranking) observe(provider string, d time.Duration, err error) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	avg, seen := k.average[provider]
+	switch {
+	case errors.Is(err, ErrLostRace):
+		if d <= avg {
+			return
+		}
+	case errors.Is(err, context.Canceled):
+		return
+	case err != nil && !errors.Is(err, ErrNotFound):
+		d = rankingFailure
+	}
+	if !seen {
+		k.average[provider] = d
+		return
+	}
+	k.average[provider] = avg + time.Duration(rankingWeight*float64(d-avg))
+}
@@ -0,0 +1,33 @@
+package cep
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRankingObserveCancellations(t *testing.T) {
+	lost := fmt.Errorf("%w: %w", ErrLostRace, context.Canceled)
+	tests := []struct {
+		name string
+		d    time.Duration
+		err  error
+		want time.Duration
+	}{
+		{"success", 200 * time.Millisecond, nil, 120 * time.Millisecond},
+		{"lost race, slower", 200 * time.Millisecond, lost, 120 * time.Millisecond},
+		{"lost race, faster", 50 * time.Millisecond, lost, 100 * time.Millisecond},
+		{"caller gave up", 5 * time.Second, context.Canceled, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewRanking(1)
+			k.observe("a", 100*time.Millisecond, nil)
+			k.observe("a", tt.d, tt.err)
+			if got := k.average["a"]; got != tt.want {
+				t.Errorf("average = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
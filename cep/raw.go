@@ -0,0 +1,59 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type (
+	rawWantedKey struct{}
+	rawSlotKey   struct{}
+)
+
+// rawSlot receives the body of the response a provider call decoded.
+type rawSlot struct {
+	body json.RawMessage
+}
+
+// WithRaw asks lookups made under ctx to keep, in Result.Raw, the body each
+// provider answered with, so a mapping bug can be seen against what the
+// provider actually sent.
+func WithRaw(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawWantedKey{}, true)
+}
+
+// withRawSlot gives one provider call a slot of its own when ctx asked for
+// raw bodies; slot is nil otherwise.
+func withRawSlot(ctx context.Context) (_ context.Context, slot *rawSlot) {
+	if wanted, _ := ctx.Value(rawWantedKey{}).(bool); !wanted {
+		return ctx, nil
+	}
+	slot = new(rawSlot)
+	return context.WithValue(ctx, rawSlotKey{}, slot), slot
+}
+
+// withoutRaw hides the slot from requests that aren't the lookup itself,
+// such as authentication, so their bodies never reach a response.
+func withoutRaw(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawSlotKey{}, (*rawSlot)(nil))
+}
+
+// wantsRaw reports whether a provider call under ctx has a slot to fill.
+func wantsRaw(ctx context.Context) bool {
+	slot, _ := ctx.Value(rawSlotKey{}).(*rawSlot)
+	return slot != nil
+}
+
+// keepRaw stores body in the slot of the call ctx belongs to, if any.
+func keepRaw(ctx context.Context, body []byte) {
+	if slot, _ := ctx.Value(rawSlotKey{}).(*rawSlot); slot != nil {
+		slot.body = body
+	}
+}
+
+func (s *rawSlot) get() json.RawMessage {
+	if s == nil {
+		return nil
+	}
+	return s.body
+}
@@ -0,0 +1,403 @@
+package cep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Result is one provider's answer to a lookup.
+type Result struct {
+	Provider string
+	Address  Address
+	Duration time.Duration
+	Err      error
+	// Raw is the body the provider answered with, kept only for lookups
+	// under a context from WithRaw.
+	Raw json.RawMessage
+}
+
+// Hooks lets callers observe lookups, e.g. to export metrics. Nil fields
+// are skipped. They are called from the provider goroutines, so they must
+// be safe for concurrent use.
+type Hooks struct {
+	// ProviderDone is called after every provider call.
+	ProviderDone func(provider string, d time.Duration, err error)
+	// Won is called with the provider whose answer Resolve returned.
+	Won func(provider string)
+}
+
+// Resolver races a set of providers. The zero value has no providers; set
+// at least Providers before use, and don't change fields while lookups are
+// running.
+type Resolver struct {
+	Providers []Provider
+	// Timeout bounds each lookup on top of the caller's context; zero means
+	// only the context applies. WithLookupTimeout overrides it per lookup.
+	Timeout time.Duration
+	// Breakers holds an optional circuit breaker per provider name; a
+	// provider whose breaker is open is left out of the race.
+	Breakers map[string]*Breaker
+	// Switches, if set, leaves the providers turned off out of the race.
+	// Unlike the other fields it may change while lookups run.
+	Switches *Switches
+	// InFlight, if set, caps concurrent provider calls.
+	InFlight Semaphore
+	// FanOut, if set, races only the providers it ranks fastest; the rest
+	// are called only when all of those fail.
+	FanOut *Ranking
+	// Health, if set, skips providers with a low recent success rate now
+	// and then; like FanOut's, the skipped ones are called only when the
+	// rest fail.
+	Health *Health
+
+	// Preferred names a provider whose answer wins if it arrives within
+	// PreferenceWindow of the first answer.
+	Preferred        string
+	PreferenceWindow time.Duration
+
+	// Primary names a provider that gets a head start: it is launched
+	// alone, and the rest of the race only after HeadStart, or as soon as
+	// Primary fails or answers without the Required fields. When Primary
+	// is usually fast this saves the other providers' quota without
+	// giving up on the race when it is slow. Zero HeadStart disables it.
+	Primary   string
+	HeadStart time.Duration
+
+	// Required names fields, e.g. street and neighborhood, an answer must
+	// fill to win outright. An answer missing some is held for up to
+	// CompletenessWindow in case a slower provider sends a fuller one; the
+	// most complete answer received by then wins. WithLookupRequired
+	// overrides Required per lookup.
+	Required           []string
+	CompletenessWindow time.Duration
+
+	// Clock, if set, replaces the real clock for the Timeout and the
+	// providers' retry backoff, e.g. to make a test's lookup time out
+	// without waiting for it.
+	Clock Clock
+
+	// Logger receives a debug line per provider call and a warning per
+	// suspicious answer; nil disables logging.
+	Logger *slog.Logger
+	Hooks  Hooks
+}
+
+// Resolve looks cep up on all of DefaultProviders concurrently using
+// client and returns the first successful answer. See Resolver.Resolve for
+// the concurrency and error semantics; the only deadline is the one on
+// ctx, so callers should always set one. It has no logger, hooks or
+// breakers, so its only I/O is the HTTP calls, which keeps it quiet enough
+// to benchmark the race; a Resolver with a nil Logger and zero Hooks is
+// just as quiet.
+func Resolve(ctx context.Context, client *http.Client, cep string) (Address, error) {
+	normalized, err := Normalize(cep)
+	if err != nil {
+		return Address{}, err
+	}
+	r := &Resolver{Providers: DefaultProviders(client)}
+	result, err := r.Resolve(ctx, normalized)
+	return result.Address, err
+}
+
+// Resolve queries every provider concurrently and returns the first
+// success; as soon as it has a winner the remaining calls are cancelled and
+// their goroutines exit on their own. Failures don't end the race: Resolve
+// only fails once every provider has failed or the deadline (ctx or
+// Timeout, whichever is sooner) expires, returning a *LookupError with each
+// failure. cep must already be normalized.
+//
+// When Preferred is set and another provider answers first, Resolve waits up
+// to PreferenceWindow for the preferred answer before settling for the first.
+// Likewise an answer missing Required fields waits up to CompletenessWindow
+// for a more complete one; a complete answer beats the preferred provider.
+//
+// With FanOut set only its top providers race at first; the others are
+// launched, within the same deadline, once every one of those has failed.
+// Providers Health skips are held back the same way.
+// Primary's head start applies within those top providers.
+func (r *Resolver) Resolve(ctx context.Context, cep string) (Result, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel(ErrLostRace)
+	required := r.Required
+	if fields, ok := LookupRequired(ctx); ok {
+		required = fields
+	}
+
+	results := make(chan Result, len(r.Providers))
+	first, reserve := r.FanOut.split(r.Providers)
+	first, skipped := r.Health.split(first)
+	reserve = append(skipped, reserve...)
+	first, hedged := r.headStart(first)
+	launched := r.launch(ctx, cep, first, results)
+	if len(launched) == 0 {
+		launched, hedged = r.launch(ctx, cep, hedged, results), nil
+	}
+	if len(launched) == 0 {
+		launched, reserve = r.launch(ctx, cep, reserve, results), nil
+	}
+	if len(launched) == 0 {
+		return Result{}, ErrNoProviders
+	}
+
+	win := func(result Result) (Result, error) {
+		if r.Hooks.Won != nil {
+			r.Hooks.Won(result.Provider)
+		}
+		return result, nil
+	}
+	pending := len(launched)
+	preferredPending := r.Preferred != "" && slices.Contains(launched, r.Preferred)
+	more := func(providers []Provider) {
+		names := r.launch(ctx, cep, providers, results)
+		pending += len(names)
+		preferredPending = preferredPending || r.Preferred != "" && slices.Contains(names, r.Preferred)
+	}
+	var hedge <-chan struct{}
+	if len(hedged) > 0 {
+		var stop func() bool
+		hedge, stop = after(ctx, r.HeadStart)
+		defer stop()
+	}
+	endHeadStart := func() {
+		more(hedged)
+		hedged, hedge = nil, nil
+	}
+
+	var fallback *Result
+	var window <-chan struct{}
+	lookupErr := &LookupError{}
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.Err != nil {
+				lookupErr.Failures = append(lookupErr.Failures, Failure{Provider: result.Provider, Err: result.Err})
+				if result.Provider == r.Primary && len(hedged) > 0 {
+					endHeadStart()
+				}
+				if result.Provider == r.Preferred {
+					preferredPending = false
+					if fallback != nil && complete(&fallback.Address, required) {
+						return win(*fallback)
+					}
+				}
+				if pending == 0 && fallback == nil && len(reserve) > 0 {
+					preferredPending = false
+					more(reserve)
+					reserve = nil
+				}
+				continue
+			}
+			if result.Provider == r.Preferred {
+				preferredPending = false
+			}
+			if len(hedged) > 0 && !complete(&result.Address, required) {
+				endHeadStart()
+			}
+			// fallback is the best answer so far: the most complete one,
+			// the preferred provider's on a tie.
+			if fallback == nil {
+				fallback = &result
+			} else if m, best := missing(&result.Address, required), missing(&fallback.Address, required); m < best || m == best && result.Provider == r.Preferred {
+				fallback = &result
+			}
+			full := complete(&fallback.Address, required)
+			if full && !preferredPending {
+				return win(*fallback)
+			}
+			if window == nil {
+				d := r.CompletenessWindow
+				if full {
+					d = r.PreferenceWindow
+				}
+				var stop func() bool
+				window, stop = after(ctx, d)
+				defer stop()
+			}
+		case <-hedge:
+			endHeadStart()
+		case <-window:
+			return win(*fallback)
+		case <-ctx.Done():
+			if fallback != nil {
+				return win(*fallback)
+			}
+			lookupErr.Failures = append(lookupErr.Failures, Failure{Err: ctx.Err()})
+			return Result{}, lookupErr
+		}
+	}
+	if fallback != nil {
+		return win(*fallback)
+	}
+	return Result{}, lookupErr
+}
+
+// All waits for every provider, up to the deadline, and returns all their
+// answers, failures included, in the order they arrived.
+func (r *Resolver) All(ctx context.Context, cep string) []Result {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel(nil)
+
+	results := make(chan Result, len(r.Providers))
+	launched := r.launch(ctx, cep, r.Providers, results)
+	all := make([]Result, 0, len(launched))
+	for range launched {
+		all = append(all, <-results)
+	}
+	return all
+}
+
+// headStart splits providers into Primary alone and the ones launched
+// after its head start; all of them race at once when it doesn't apply.
+func (r *Resolver) headStart(providers []Provider) (first, hedged []Provider) {
+	if r.Primary == "" || r.HeadStart <= 0 || len(providers) < 2 {
+		return providers, nil
+	}
+	i := slices.IndexFunc(providers, func(p Provider) bool { return p.Name() == r.Primary })
+	if i < 0 {
+		return providers, nil
+	}
+	return providers[i : i+1], slices.Delete(slices.Clone(providers), i, i+1)
+}
+
+// complete reports whether a fills every one of the required fields.
+func complete(a *Address, required []string) bool {
+	return missing(a, required) == 0
+}
+
+type timeoutKey struct{}
+
+// WithLookupTimeout overrides Resolver.Timeout for lookups under ctx, e.g.
+// with a deadline the client asked for. Unlike a deadline on ctx it still
+// applies when the lookup detaches from the caller's cancellation.
+func WithLookupTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+// LookupTimeout returns the override set by WithLookupTimeout, if any.
+func LookupTimeout(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(timeoutKey{}).(time.Duration)
+	return d, ok
+}
+
+type requiredKey struct{}
+
+// WithLookupRequired overrides Resolver.Required for lookups under ctx,
+// e.g. to hold out for a provider that sends lat and lng when the caller
+// can't use an answer without them.
+func WithLookupRequired(ctx context.Context, fields ...string) context.Context {
+	return context.WithValue(ctx, requiredKey{}, fields)
+}
+
+// LookupRequired returns the override set by WithLookupRequired, if any.
+func LookupRequired(ctx context.Context) ([]string, bool) {
+	fields, ok := ctx.Value(requiredKey{}).([]string)
+	return fields, ok
+}
+
+// withTimeout puts the lookup under the Resolver's clock and timeout. Its
+// cancel takes the cause, so lookups cancelled once the race is won can be
+// told apart from ones that ran out of time.
+func (r *Resolver) withTimeout(ctx context.Context) (context.Context, context.CancelCauseFunc) {
+	ctx = withClock(ctx, r.Clock)
+	timeout := r.Timeout
+	if d, ok := LookupTimeout(ctx); ok {
+		timeout = d
+	}
+	if timeout <= 0 {
+		return context.WithCancelCause(ctx)
+	}
+	return withClockTimeoutCause(ctx, timeout)
+}
+
+// launch starts a lookup on each of providers that is switched on and
+// whose circuit allows it, and returns the names of the providers
+// launched; each sends one result. The results channel must be buffered
+// for every provider of the Resolver so the losing goroutines can always
+// deliver and exit once the context is cancelled, even after the caller
+// has returned.
+func (r *Resolver) launch(ctx context.Context, cep string, providers []Provider, results chan<- Result) []string {
+	var launched []string
+	for _, p := range providers {
+		if !r.Switches.Enabled(p.Name()) {
+			r.debug(ctx, "provedor ignorado, desligado", "provider", p.Name(), "cep", cep)
+			continue
+		}
+		breaker := r.Breakers[p.Name()]
+		if !breaker.allow() {
+			r.debug(ctx, "provedor ignorado, circuito aberto", "provider", p.Name(), "cep", cep)
+			continue
+		}
+		launched = append(launched, p.Name())
+		go func(p Provider) {
+			if err := r.InFlight.acquire(ctx); err != nil {
+				breaker.skip()
+				results <- Result{Provider: p.Name(), Err: err}
+				return
+			}
+			lookupCtx, raw := withRawSlot(ctx)
+			clock := clockOf(ctx)
+			start := clock.Now()
+			address, err := p.Lookup(lookupCtx, cep)
+			duration := clock.Now().Sub(start)
+			err = cancelReason(ctx, err)
+			r.InFlight.release()
+			breaker.record(err)
+			r.FanOut.observe(p.Name(), duration, err)
+			r.Health.observe(p.Name(), err)
+			if (errors.Is(err, ErrIncomplete) || errors.Is(err, ErrUnknownField)) && r.Logger != nil {
+				r.Logger.WarnContext(ctx, "resposta suspeita do provedor, o formato pode ter mudado", "provider", p.Name(), "cep", cep, "err", err)
+			}
+			if r.Hooks.ProviderDone != nil {
+				r.Hooks.ProviderDone(p.Name(), duration, err)
+			}
+			if errors.Is(err, ErrLostRace) {
+				r.debug(ctx, "consulta ao provedor", "provider", p.Name(), "cep", cep, "duration_ms", duration.Milliseconds(), "status", "lost_race")
+				results <- Result{Provider: p.Name(), Duration: duration, Err: err}
+				return
+			}
+			if err != nil {
+				args := []any{"provider", p.Name(), "cep", cep, "duration_ms", duration.Milliseconds(), "status", "error", "err", err}
+				var cte *ContentTypeError
+				if errors.As(err, &cte) {
+					args = append(args, "body", string(cte.Body))
+				}
+				r.debug(ctx, "consulta ao provedor", args...)
+				results <- Result{Provider: p.Name(), Duration: duration, Err: err}
+				return
+			}
+			r.debug(ctx, "consulta ao provedor", "provider", p.Name(), "cep", cep, "duration_ms", duration.Milliseconds(), "status", "ok")
+			results <- Result{Provider: p.Name(), Address: address, Duration: duration, Raw: raw.get()}
+		}(p)
+	}
+	return launched
+}
+
+// cancelReason tells why a call under ctx was cancelled. One cancelled
+// because the race was won wraps ErrLostRace too; one that ran out of time
+// is reported as context.DeadlineExceeded even when it only saw a child of
+// the deadline's context being cancelled, as happens under a fake Clock.
+func cancelReason(ctx context.Context, err error) error {
+	if !errors.Is(err, context.Canceled) {
+		return err
+	}
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, ErrLostRace):
+		return fmt.Errorf("%w: %w", ErrLostRace, err)
+	case errors.Is(cause, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+	}
+	return err
+}
+
+func (r *Resolver) debug(ctx context.Context, msg string, args ...any) {
+	if r.Logger != nil {
+		r.Logger.DebugContext(ctx, msg, args...)
+	}
+}
@@ -0,0 +1,167 @@
+package cep
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	viaCepBody    = `{"cep":"01001-000","logradouro":"Praça da Sé","complemento":"lado ímpar","bairro":"Sé","localidade":"São Paulo","uf":"SP","ibge":"3550308"}`
+	brasilAPIBody = `{"cep":"01001000","state":"SP","city":"São Paulo","neighborhood":"Sé","street":"Praça da Sé","service":"viacep","location":{"type":"Point","coordinates":{}}}`
+)
+
+// latencyServer answers every request with body as JSON after delay.
+func latencyServer(tb testing.TB, delay time.Duration, body string) *httptest.Server {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	tb.Cleanup(srv.Close)
+	return srv
+}
+
+// raceResolver races ViaCep and BrasilAPI against local servers, the
+// second one slow enough to always lose.
+func raceResolver(tb testing.TB, fast, slow time.Duration) *Resolver {
+	tb.Helper()
+	via := latencyServer(tb, fast, viaCepBody)
+	brasil := latencyServer(tb, slow, brasilAPIBody)
+	return &Resolver{Providers: []Provider{
+		ViaCep{Client: via.Client(), BaseURL: via.URL},
+		BrasilAPI{Client: brasil.Client(), BaseURL: brasil.URL},
+	}}
+}
+
+// TestResolveLosersExit runs many races whose loser is still waiting on
+// its upstream when the winner answers, and checks that every goroutine
+// they started is gone once the connections are closed.
+func TestResolveLosersExit(t *testing.T) {
+	via := latencyServer(t, 0, viaCepBody)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	r := &Resolver{Providers: []Provider{
+		ViaCep{Client: via.Client(), BaseURL: via.URL},
+		BrasilAPI{Client: slow.Client(), BaseURL: slow.URL},
+	}}
+	closeIdle := func() {
+		via.Client().CloseIdleConnections()
+		slow.Client().CloseIdleConnections()
+	}
+
+	if _, err := r.Resolve(context.Background(), "01001000"); err != nil {
+		t.Fatal(err)
+	}
+	closeIdle()
+	baseline := settledGoroutines(0)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if _, err := r.Resolve(context.Background(), "01001000"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	closeIdle()
+
+	if got := settledGoroutines(baseline); got > baseline {
+		t.Errorf("%d goroutines left after the races, %d before", got, baseline)
+	}
+}
+
+// settledGoroutines waits up to 5s for the goroutine count to drop to
+// want, and returns the last count seen; with want 0 it just lets the
+// count settle.
+func settledGoroutines(want int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	n := runtime.NumGoroutine()
+	for time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		prev := n
+		n = runtime.NumGoroutine()
+		if want > 0 && n <= want || want == 0 && n == prev {
+			return n
+		}
+	}
+	return n
+}
+
+func benchmarkResolve(b *testing.B, r *Resolver) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := r.Resolve(ctx, "01001000"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkResolve measures the race with no upstream latency, so the
+// time is the race's own overhead plus local HTTP.
+func BenchmarkResolve(b *testing.B) {
+	benchmarkResolve(b, raceResolver(b, 0, 0))
+}
+
+// BenchmarkResolveLatency has one provider answer in 1ms and the other in
+// 5ms; a run nearing 5ms means the race waits on the loser.
+func BenchmarkResolveLatency(b *testing.B) {
+	benchmarkResolve(b, raceResolver(b, time.Millisecond, 5*time.Millisecond))
+}
+
+func BenchmarkResolveParallel(b *testing.B) {
+	r := raceResolver(b, time.Millisecond, 5*time.Millisecond)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := r.Resolve(ctx, "01001000"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestResolveLookupRequired(t *testing.T) {
+	lat, lng := -23.55, -46.63
+	r := &Resolver{
+		Providers: []Provider{
+			funcProvider{name: "plain", lookup: func(ctx context.Context, cep string) (Address, error) {
+				return Address{Cep: cep, State: "SP", City: "São Paulo", Source: "plain"}, nil
+			}},
+			funcProvider{name: "geo", lookup: func(ctx context.Context, cep string) (Address, error) {
+				time.Sleep(20 * time.Millisecond)
+				return Address{Cep: cep, State: "SP", City: "São Paulo", Lat: &lat, Lng: &lng, Source: "geo"}, nil
+			}},
+		},
+		CompletenessWindow: time.Second,
+	}
+
+	result, err := r.Resolve(context.Background(), "01001000")
+	if err != nil || result.Provider != "plain" {
+		t.Fatalf("Resolve = %v, %v, want the first answer", result.Provider, err)
+	}
+	result, err = r.Resolve(WithLookupRequired(context.Background(), "lat", "lng"), "01001000")
+	if err != nil || result.Provider != "geo" {
+		t.Fatalf("Resolve with lat,lng required = %v, %v, want the answer with coordinates", result.Provider, err)
+	}
+}
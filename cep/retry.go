@@ -0,0 +1,90 @@
+package cep
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+const retryBaseDelay = 100 * time.Millisecond
+
+// retryProvider retries transient failures (5xx and network errors) with
+// exponential backoff, never sleeping past the context deadline.
+type retryProvider struct {
+	Provider
+	maxRetries int
+}
+
+// WithRetry wraps p so that 5xx responses and network errors are retried up
+// to maxRetries times, waiting 100ms, 200ms, 400ms... between attempts.
+// Not-found and other 4xx answers are never retried.
+func WithRetry(p Provider, maxRetries int) Provider {
+	if maxRetries <= 0 {
+		return p
+	}
+	return retryProvider{Provider: p, maxRetries: maxRetries}
+}
+
+func (p retryProvider) Lookup(ctx context.Context, cep string) (Address, error) {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		address, err := p.Provider.Lookup(ctx, cep)
+		if err == nil || attempt >= p.maxRetries || !retryable(ctx, err) {
+			return address, err
+		}
+		if left, ok := timeLeft(ctx); ok && left < delay {
+			return Address{}, err
+		}
+		if !sleep(ctx, delay) {
+			return Address{}, err
+		}
+		delay *= 2
+	}
+}
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500
+	}
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// emptyRetryProvider retries a blank answer once; see WithRetryOnEmpty.
+type emptyRetryProvider struct {
+	Provider
+	logger *slog.Logger
+}
+
+// WithRetryOnEmpty wraps p so that an answer rejected as ErrIncomplete,
+// like the 200 with every field blank ViaCep sometimes sends for CEPs that
+// exist, is retried once after a short pause before counting as a failure.
+// Each retry is logged on logger, when set, with whether it helped.
+func WithRetryOnEmpty(p Provider, logger *slog.Logger) Provider {
+	return emptyRetryProvider{Provider: p, logger: logger}
+}
+
+func (p emptyRetryProvider) Lookup(ctx context.Context, cep string) (Address, error) {
+	address, err := p.Provider.Lookup(ctx, cep)
+	if !errors.Is(err, ErrIncomplete) {
+		return address, err
+	}
+	if left, ok := timeLeft(ctx); ok && left < retryBaseDelay {
+		return address, err
+	}
+	if !sleep(ctx, retryBaseDelay) {
+		return address, err
+	}
+
+	address, retryErr := p.Provider.Lookup(ctx, cep)
+	if p.logger != nil {
+		p.logger.InfoContext(ctx, "resposta vazia do provedor, consultado de novo", "provider", p.Name(), "cep", cep, "err", err, "helped", retryErr == nil)
+	}
+	return address, retryErr
+}
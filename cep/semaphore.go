@@ -0,0 +1,35 @@
+package cep
+
+import "context"
+
+// Semaphore caps the number of concurrent upstream lookups; share one
+// between resolvers to cap them together. A nil Semaphore never blocks.
+type Semaphore chan struct{}
+
+// NewSemaphore returns a semaphore with n slots, or nil (no limit) when n
+// is not positive.
+func NewSemaphore(n int) Semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(Semaphore, n)
+}
+
+// acquire waits for a free slot, giving up when ctx is done.
+func (s Semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s Semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
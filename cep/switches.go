@@ -0,0 +1,37 @@
+package cep
+
+import "sync"
+
+// Switches turns providers off and on while lookups run, e.g. to take one
+// out of the race during its outage without a restart. Providers start on.
+// A nil *Switches leaves every provider on.
+type Switches struct {
+	mu  sync.RWMutex
+	off map[string]bool
+}
+
+// NewSwitches returns switches with every provider on.
+func NewSwitches() *Switches {
+	return &Switches{off: make(map[string]bool)}
+}
+
+// Set turns the named provider on or off.
+func (s *Switches) Set(provider string, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if on {
+		delete(s.off, provider)
+	} else {
+		s.off[provider] = true
+	}
+}
+
+// Enabled reports whether the named provider may take part in lookups.
+func (s *Switches) Enabled(provider string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.off[provider]
+}
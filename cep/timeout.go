@@ -0,0 +1,29 @@
+package cep
+
+import (
+	"context"
+	"time"
+)
+
+// timeoutProvider bounds each lookup on its own provider, independently of
+// the deadline the caller puts on the whole race.
+type timeoutProvider struct {
+	Provider
+	timeout time.Duration
+}
+
+// WithTimeout wraps p so that each Lookup, retries included, is abandoned
+// after d even when ctx allows longer. The context's own deadline still
+// applies when it is sooner. A non-positive d returns p unchanged.
+func WithTimeout(p Provider, d time.Duration) Provider {
+	if d <= 0 {
+		return p
+	}
+	return timeoutProvider{Provider: p, timeout: d}
+}
+
+func (p timeoutProvider) Lookup(ctx context.Context, cep string) (Address, error) {
+	ctx, cancel := withClockTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.Provider.Lookup(ctx, cep)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// runLookup resolves a single CEP with the same race used by the server and
+// returns the process exit code.
+func runLookup(s *server, raw string) int {
+	code, err := cep.Normalize(raw)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	result := s.lookup(context.Background(), code)
+	if result.Err != nil {
+		fmt.Fprintln(os.Stderr, "Erro:", result.Err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		fmt.Fprintln(os.Stderr, "Erro:", err)
+		return 1
+	}
+	return 0
+}
+
+// warmup pings every provider switched on concurrently, so their
+// connections are in the pool, handshakes done, before the server takes
+// traffic. It gives up after timeout; a provider that can't be reached is
+// only logged, since the race works around it.
+func warmup(s *server, timeout time.Duration) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var warmed atomic.Int32
+	for _, p := range s.resolver.Providers {
+		if !s.resolver.Switches.Enabled(p.Name()) {
+			continue
+		}
+		wg.Add(1)
+		go func(p cep.Provider) {
+			defer wg.Done()
+			if err := p.Ping(ctx); err != nil {
+				slog.Warn("provedor não aquecido", "provider", p.Name(), "err", err)
+				return
+			}
+			warmed.Add(1)
+		}(p)
+	}
+	wg.Wait()
+	slog.Info("conexões com os provedores aquecidas", "warmed", warmed.Load(), "duration_ms", time.Since(start).Milliseconds())
+}
+
+// preload warms the cache with the CEPs listed in path before the server
+// takes traffic, giving up after timeout. It fails when more than
+// maxFailed of them, as a fraction, couldn't be resolved, so a deploy
+// whose providers are unreachable stops here instead of starting cold.
+func preload(s *server, path string, timeout time.Duration, maxFailed float64) error {
+	ceps, err := readCEPFile(path)
+	if err != nil {
+		return err
+	}
+	if len(ceps) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp := s.warm(ctx, ceps)
+	for _, item := range resp.Failed {
+		slog.Warn("CEP não pré-carregado", "cep", item.Cep, "status", item.Status, "err", item.Erro)
+	}
+	total := resp.Warmed + len(resp.Failed)
+	slog.Info("pré-carga do cache concluída", "file", path, "warmed", resp.Warmed, "failed", len(resp.Failed), "duration_ms", time.Since(start).Milliseconds())
+	if float64(len(resp.Failed)) > maxFailed*float64(total) {
+		return fmt.Errorf("pré-carga: %d de %d CEPs falharam, acima do limite de %.0f%%", len(resp.Failed), total, maxFailed*100)
+	}
+	return nil
+}
+
+// readCEPFile reads one CEP per line; blank lines and lines starting with #
+// are skipped.
+func readCEPFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ceps []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ceps = append(ceps, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao ler %s: %w", path, err)
+	}
+	return ceps, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// newHTTPClient builds the client shared by every provider. Each lookup is
+// still bounded by its request context; the client timeout is only a
+// backstop for calls made without a deadline.
+//
+// Every request goes through proxy when it is set, -proxy taking
+// precedence over the environment. Otherwise HTTPS_PROXY, HTTP_PROXY and
+// NO_PROXY (or their lowercase forms) apply as for any Go program. HTTPS
+// upstreams are reached through a CONNECT tunnel, so TLS still ends at the
+// provider.
+func newHTTPClient(proxy *url.URL) *http.Client {
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != nil {
+		proxyFunc = http.ProxyURL(proxy)
+	}
+	transport := &http.Transport{
+		Proxy: proxyFunc,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}
+}
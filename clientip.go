@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// realIP works out the client's address once per request and stores it in
+// the context for clientIP. X-Forwarded-For and X-Real-IP are only honoured
+// when the connection comes from one of the trusted proxies; otherwise
+// anyone could pick the address they are rate limited and logged under.
+func realIP(trusted []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.RemoteAddr
+		if addr, ok := resolveClientIP(r, trusted); ok {
+			ip = addr.String()
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// clientIP returns the address realIP resolved for r, or the connection's
+// peer address when the request didn't go through it.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	if peer, ok := parseIP(r.RemoteAddr); ok {
+		return peer.String()
+	}
+	return r.RemoteAddr
+}
+
+// resolveClientIP walks X-Forwarded-For from the right, skipping trusted
+// proxies, so the answer is the last hop that no trusted proxy vouches
+// for. Entries to its left were written by the client and are ignored.
+// Without X-Forwarded-For, X-Real-IP is used. ok is false when the peer
+// address itself can't be parsed.
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	peer, ok := parseIP(r.RemoteAddr)
+	if !ok || !isTrusted(peer, trusted) {
+		return peer, ok
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip, ok := parseIP(r.Header.Get("X-Real-IP")); ok {
+			return ip, true
+		}
+		return peer, true
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseIP(hops[i])
+		if !ok {
+			break
+		}
+		client = ip
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return client, true
+}
+
+// parseIP accepts a bare address or one with a port, IPv6 ones in
+// brackets, and unmaps IPv4-mapped IPv6 so both forms count as one client.
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+type compareItem struct {
+	Data       *cep.Address `json:"data,omitempty"`
+	DurationMs int64        `json:"duracao_ms,omitempty"`
+	Erro       string       `json:"erro,omitempty"`
+}
+
+type discrepancy struct {
+	Field  string            `json:"field"`
+	Values map[string]string `json:"values"`
+}
+
+type compareResponse struct {
+	Cep           string                 `json:"cep"`
+	Results       map[string]compareItem `json:"results"`
+	Discrepancies []discrepancy          `json:"discrepancies"`
+}
+
+// handleCompare waits for every provider, up to the lookup timeout, and
+// returns their answers side by side. Unlike handleCEP it never uses the
+// cache, since the point is auditing what the upstreams say right now.
+func (s *server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	code, err := cep.Normalize(r.PathValue("cep"))
+	if err != nil {
+		status, errCode := normalizeError(err)
+		writeError(w, r, status, errCode, err.Error())
+		return
+	}
+
+	results := s.resolver.All(r.Context(), code)
+	resp := compareResponse{Cep: code, Results: make(map[string]compareItem, len(results))}
+	addresses := make(map[string]cep.Address, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			resp.Results[result.Provider] = compareItem{DurationMs: result.Duration.Milliseconds(), Erro: result.Err.Error()}
+			continue
+		}
+		resp.Results[result.Provider] = compareItem{Data: &result.Address, DurationMs: result.Duration.Milliseconds()}
+		addresses[result.Provider] = result.Address
+	}
+	resp.Discrepancies = findDiscrepancies(addresses)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+var comparedFields = []struct {
+	name  string
+	value func(cep.Address) string
+}{
+	{"cep", func(a cep.Address) string { return strings.ReplaceAll(a.Cep, "-", "") }},
+	{"state", func(a cep.Address) string { return a.State }},
+	{"city", func(a cep.Address) string { return a.City }},
+	{"neighborhood", func(a cep.Address) string { return a.Neighborhood }},
+	{"street", func(a cep.Address) string { return a.Street }},
+}
+
+// findDiscrepancies lists the fields on which the providers disagree,
+// ignoring case and surrounding whitespace.
+func findDiscrepancies(addresses map[string]cep.Address) []discrepancy {
+	discrepancies := []discrepancy{}
+	for _, field := range comparedFields {
+		values := make(map[string]string, len(addresses))
+		var first string
+		differ := false
+		for source, address := range addresses {
+			v := strings.TrimSpace(field.value(address))
+			if len(values) == 0 {
+				first = v
+			} else if !strings.EqualFold(v, first) {
+				differ = true
+			}
+			values[source] = v
+		}
+		if differ {
+			discrepancies = append(discrepancies, discrepancy{Field: field.name, Values: values})
+		}
+	}
+	return discrepancies
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+type distanceResponse struct {
+	From       cep.Address `json:"from"`
+	To         cep.Address `json:"to"`
+	DistanceKm float64     `json:"distance_km"`
+}
+
+// located is the outcome of resolving one end of a distance.
+type located struct {
+	address cep.Address
+	err     error
+}
+
+// handleDistance serves /distance?from={cep}&to={cep} with the straight
+// line distance between the two CEPs, in kilometres rounded to metres. It
+// needs coordinates for both, which only some providers supply.
+func (s *server) handleDistance(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("from") == "" || q.Get("to") == "" {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "Uso correto: /distance?from={cep}&to={cep}")
+		return
+	}
+	var codes [2]string
+	for i, param := range []string{"from", "to"} {
+		code, err := cep.Normalize(q.Get(param))
+		if err != nil {
+			status, errCode := normalizeError(err)
+			writeError(w, r, status, errCode, param+": "+err.Error())
+			return
+		}
+		codes[i] = code
+	}
+
+	var ends [2]located
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ends[1].address, ends[1].err = s.locate(r.Context(), codes[1])
+	}()
+	ends[0].address, ends[0].err = s.locate(r.Context(), codes[0])
+	<-done
+
+	for i, param := range []string{"from", "to"} {
+		err := ends[i].err
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, errNoCoordinates) {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeNoCoordinates, fmt.Sprintf("%s: nenhum provedor informa as coordenadas do CEP %s", param, codes[i]))
+			return
+		}
+		slog.InfoContext(r.Context(), "consulta de distância falhou", "cep", codes[i], "err", err)
+		status, errCode, message := lookupError(r.Context(), err)
+		writeError(w, r, status, errCode, param+": "+message)
+		return
+	}
+
+	km, _ := cep.Distance(ends[0].address, ends[1].address)
+	resp := distanceResponse{From: ends[0].address, To: ends[1].address, DistanceKm: math.Round(km*1000) / 1000}
+	resp.From.Cep, resp.To.Cep = presentCEP(r, codes[0]), presentCEP(r, codes[1])
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+var errNoCoordinates = errors.New("sem coordenadas")
+
+// locate resolves code into an address with coordinates. The race winner
+// often has none, so then every provider is asked and the coordinates are
+// taken from whichever supplies them, as /cep/{cep}/merge does.
+func (s *server) locate(ctx context.Context, code string) (cep.Address, error) {
+	result := s.lookup(ctx, code)
+	if result.Err != nil {
+		return cep.Address{}, result.Err
+	}
+	address := result.Data
+	if address.Lat != nil && address.Lng != nil {
+		return address, nil
+	}
+	merged, ok := cep.Merge(s.resolver.All(ctx, code))
+	if ctx.Err() != nil {
+		return cep.Address{}, ctx.Err()
+	}
+	if !ok || merged.Address.Lat == nil || merged.Address.Lng == nil {
+		return cep.Address{}, errNoCoordinates
+	}
+	address.Lat, address.Lng = merged.Address.Lat, merged.Address.Lng
+	return address, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// Error codes returned in the error envelope. They are part of the API:
+// clients switch on them, so existing values must not change.
+const (
+	errCodeBadRequest            = "BAD_REQUEST"
+	errCodeInvalidCEP            = "INVALID_CEP"
+	errCodeImpossibleCEP         = "IMPOSSIBLE_CEP"
+	errCodeInvalidAddress        = "INVALID_ADDRESS"
+	errCodeNotFound              = "CEP_NOT_FOUND"
+	errCodeInvalidCallback       = "INVALID_CALLBACK"
+	errCodeInvalidFields         = "INVALID_FIELDS"
+	errCodeInvalidLang           = "INVALID_LANG"
+	errCodeProviderNotFound      = "PROVIDER_NOT_FOUND"
+	errCodeNoCoordinates         = "NO_COORDINATES"
+	errCodeNotAcceptable         = "NOT_ACCEPTABLE"
+	errCodeMethodNotAllowed      = "METHOD_NOT_ALLOWED"
+	errCodeBatchTooLarge         = "BATCH_TOO_LARGE"
+	errCodeBodyTooLarge          = "BODY_TOO_LARGE"
+	errCodeIdempotencyReused     = "IDEMPOTENCY_KEY_REUSED"
+	errCodeIdempotencyInProgress = "IDEMPOTENCY_IN_PROGRESS"
+	errCodeRateLimited           = "RATE_LIMITED"
+	errCodeTimeout               = "UPSTREAM_TIMEOUT"
+	errCodeRequestTimeout        = "REQUEST_TIMEOUT"
+	errCodeClientClosed          = "CLIENT_CLOSED_REQUEST"
+	errCodeUnavailable           = "NO_PROVIDER_AVAILABLE"
+	errCodeUpstream              = "UPSTREAM_ERROR"
+	errCodeHistoryDisabled       = "HISTORY_DISABLED"
+	errCodeUnauthorized          = "UNAUTHORIZED"
+	errCodeShuttingDown          = "SHUTTING_DOWN"
+	errCodeInternal              = "INTERNAL_ERROR"
+)
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details lists each invalid input of a validation error, so a client
+	// can fix them all in one go.
+	Details []fieldError `json:"details,omitempty"`
+}
+
+// fieldError is one invalid input: the parameter and what is wrong with it.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// writeError sends the JSON error envelope used by every failure path,
+// e.g. {"error":{"code":"CEP_NOT_FOUND","message":"CEP não encontrado"}},
+// in the language r asks for; message is the Portuguese text.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	message = localize(code, errorLanguage(w, r), message)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+// writeBodyError answers a request body that failed to decode: 413 when
+// limitBody cut it off, otherwise 400 with message.
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, fmt.Sprintf("Corpo excede o limite de %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, errCodeBadRequest, message)
+}
+
+// writeFieldErrors sends a 400 listing every problem in details; the
+// message joins them, for clients that only read it. English clients get
+// the catalog message, the details staying in Portuguese.
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, code string, problems []fieldError) {
+	messages := make([]string, len(problems))
+	for i, p := range problems {
+		messages[i] = p.Message
+	}
+	message := localize(code, errorLanguage(w, r), strings.Join(messages, "; "))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiError{Code: code, Message: message, Details: problems}})
+}
+
+// normalizeError maps a cep.Normalize failure to its HTTP status and error
+// code: 400 for malformed input, 422 for well-formed CEPs that can't exist.
+func normalizeError(err error) (status int, code string) {
+	if errors.Is(err, cep.ErrImpossible) {
+		return http.StatusUnprocessableEntity, errCodeImpossibleCEP
+	}
+	return http.StatusBadRequest, errCodeInvalidCEP
+}
+
+// statusClientClosedRequest is nginx's non-standard status for requests
+// whose client went away before the answer was ready. Nobody reads the
+// response, but it keeps these out of the 5xx in logs and metrics.
+const statusClientClosedRequest = 499
+
+// lookupError maps a failed lookup to its HTTP status and error code. ctx is
+// the request context: when it is done the client gave up or ran out of
+// time, otherwise a deadline means our upstreams were too slow.
+func lookupError(ctx context.Context, err error) (status int, code, message string) {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return statusClientClosedRequest, errCodeClientClosed, errorMessages[errCodeClientClosed][langPT]
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return http.StatusRequestTimeout, errCodeRequestTimeout, errorMessages[errCodeRequestTimeout][langPT]
+	case errors.Is(err, cep.ErrNotFound):
+		return http.StatusNotFound, errCodeNotFound, errorMessages[errCodeNotFound][langPT]
+	case errors.Is(err, cep.ErrNoProviders):
+		return http.StatusServiceUnavailable, errCodeUnavailable, errorMessages[errCodeUnavailable][langPT]
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, errCodeTimeout, errorMessages[errCodeTimeout][langPT]
+	default:
+		detail := err.Error()
+		var lookupErr *cep.LookupError
+		if errors.As(err, &lookupErr) {
+			detail = lookupErr.Detail()
+		}
+		return http.StatusInternalServerError, errCodeUpstream, errorMessages[errCodeUpstream][langPT] + ": " + detail
+	}
+}
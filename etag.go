@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// addressETag derives a weak ETag from the normalized address and the
+// response format. It is weak because the body also carries per-request
+// details, like the provider's response time, that don't change its meaning.
+func addressETag(address cep.Address, format string) string {
+	b, _ := json.Marshal(address)
+	sum := sha256.Sum256(append(b, format...))
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
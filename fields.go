@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+const (
+	langEN = "en"
+	langPT = "pt"
+)
+
+// addressFields are the names accepted by the fields parameter, in the
+// order responses list them, with the Portuguese name lang=pt uses. value
+// reports false for absent coordinates, which are omitted as in the full
+// response.
+var addressFields = []struct {
+	name  string
+	pt    string
+	value func(a *cep.Address) (any, bool)
+}{
+	{"cep", "cep", func(a *cep.Address) (any, bool) { return a.Cep, true }},
+	{"state", "uf", func(a *cep.Address) (any, bool) { return a.State, true }},
+	{"city", "cidade", func(a *cep.Address) (any, bool) { return a.City, true }},
+	{"neighborhood", "bairro", func(a *cep.Address) (any, bool) { return a.Neighborhood, true }},
+	{"street", "logradouro", func(a *cep.Address) (any, bool) { return a.Street, true }},
+	{"source", "fonte", func(a *cep.Address) (any, bool) { return a.Source, true }},
+	{"lat", "latitude", func(a *cep.Address) (any, bool) { return deref(a.Lat) }},
+	{"lng", "longitude", func(a *cep.Address) (any, bool) { return deref(a.Lng) }},
+}
+
+// parseLang reads the lang parameter, which picks the address field names:
+// English, the default and the names of cep.Address, or Portuguese.
+func parseLang(raw string) (string, error) {
+	switch raw {
+	case "", langEN:
+		return langEN, nil
+	case langPT:
+		return langPT, nil
+	}
+	return "", fmt.Errorf("lang inválido %q: use en ou pt", raw)
+}
+
+func deref(f *float64) (any, bool) {
+	if f == nil {
+		return nil, false
+	}
+	return *f, true
+}
+
+// parseFields reads a comma-separated fields parameter into the set of
+// address fields to return, keyed by their English names; the Portuguese
+// ones are accepted too. An empty parameter means all of them and yields
+// nil.
+func parseFields(raw string) (map[string]bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	fields := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, f := range addressFields {
+			if f.name == name || f.pt == name {
+				fields[f.name] = true
+				known = true
+			}
+		}
+		if !known {
+			names := make([]string, len(addressFields))
+			for i, f := range addressFields {
+				names[i] = f.name
+			}
+			return nil, fmt.Errorf("campo desconhecido %q em fields: use %s", name, strings.Join(names, ", "))
+		}
+	}
+	return fields, nil
+}
+
+// fieldsKey is a canonical form of a field set, for the ETag.
+func fieldsKey(fields map[string]bool) string {
+	var key []string
+	for _, f := range addressFields {
+		if fields[f.name] {
+			key = append(key, f.name)
+		}
+	}
+	return strings.Join(key, ",")
+}
+
+// partialAddress encodes only the chosen fields of an address, all of them
+// when fields is nil, under the names of lang, in JSON and XML alike.
+type partialAddress struct {
+	address cep.Address
+	fields  map[string]bool
+	lang    string
+}
+
+func (p partialAddress) wants(name string) bool {
+	return p.fields == nil || p.fields[name]
+}
+
+func (p partialAddress) key(i int) string {
+	if p.lang == langPT {
+		return addressFields[i].pt
+	}
+	return addressFields[i].name
+}
+
+func (p partialAddress) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range addressFields {
+		v, ok := f.value(&p.address)
+		if !ok || !p.wants(f.name) {
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:%s", p.key(i), b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (p partialAddress) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for i, f := range addressFields {
+		v, ok := f.value(&p.address)
+		if !ok || !p.wants(f.name) {
+			continue
+		}
+		if err := e.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: p.key(i)}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// partialResult is resultadoAPI with only some of the address fields, or
+// with them renamed by lang.
+type partialResult struct {
+	XMLName    xml.Name        `json:"-" xml:"resultado"`
+	Origem     string          `json:"origem" xml:"origem"`
+	Data       partialAddress  `json:"data" xml:"data"`
+	Provenance string          `json:"source" xml:"source"`
+	DurationMs int64           `json:"duracao_ms,omitempty" xml:"duracao_ms,omitempty"`
+	Raw        json.RawMessage `json:"raw,omitempty" xml:"-"`
+	CachedAt   *time.Time      `json:"cached_at,omitempty" xml:"cached_at,omitempty"`
+}
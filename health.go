@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReady pings every provider switched on concurrently and reports
+// ready as long as at least one of them is reachable within the lookup
+// timeout.
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	type pingResult struct {
+		name string
+		err  error
+	}
+	var providers []cep.Provider
+	statuses := make(map[string]string, len(s.resolver.Providers))
+	for _, p := range s.resolver.Providers {
+		if !s.resolver.Switches.Enabled(p.Name()) {
+			statuses[p.Name()] = "desligado"
+			continue
+		}
+		providers = append(providers, p)
+	}
+	results := make(chan pingResult, len(providers))
+	for _, p := range providers {
+		go func(p cep.Provider) {
+			results <- pingResult{name: p.Name(), err: p.Ping(ctx)}
+		}(p)
+	}
+
+	status := "not ready"
+	code := http.StatusServiceUnavailable
+	for range providers {
+		res := <-results
+		if res.err != nil {
+			statuses[res.name] = res.err.Error()
+			continue
+		}
+		statuses[res.name] = "ok"
+		status = "ready"
+		code = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":    status,
+		"providers": statuses,
+	})
+}
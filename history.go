@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// historyBuffer is how many entries may wait for the writer before new
+	// ones are dropped; the response path never blocks on the database.
+	historyBuffer = 1024
+
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS lookups (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	looked_up_at TEXT    NOT NULL,
+	cep          TEXT    NOT NULL,
+	source       TEXT    NOT NULL,
+	duration_ms  INTEGER NOT NULL,
+	client_ip    TEXT    NOT NULL
+);
+`
+
+type historyEntry struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	Cep        string    `json:"cep"`
+	Source     string    `json:"source"`
+	DurationMs int64     `json:"duracao_ms"`
+	ClientIP   string    `json:"client_ip"`
+}
+
+// historyStore keeps an audit trail of successful lookups in SQLite.
+// Entries are written by a single goroutine fed through a buffered channel.
+type historyStore struct {
+	db      *sql.DB
+	entries chan historyEntry
+	done    chan struct{}
+}
+
+func openHistory(dsn string) (*historyStore, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir histórico: %v", err)
+	}
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY
+	// between the writer and /history reads.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erro ao criar tabela de histórico: %v", err)
+	}
+
+	h := &historyStore{db: db, entries: make(chan historyEntry, historyBuffer), done: make(chan struct{})}
+	go h.write()
+	return h, nil
+}
+
+func (h *historyStore) write() {
+	defer close(h.done)
+	for e := range h.entries {
+		_, err := h.db.Exec(`INSERT INTO lookups (looked_up_at, cep, source, duration_ms, client_ip) VALUES (?, ?, ?, ?, ?)`,
+			e.Time.UTC().Format(time.RFC3339Nano), e.Cep, e.Source, e.DurationMs, e.ClientIP)
+		if err != nil {
+			slog.Error("erro ao gravar histórico", "cep", e.Cep, "err", err)
+		}
+	}
+}
+
+// Record queues e for writing, dropping it if the writer is too far behind.
+func (h *historyStore) Record(e historyEntry) {
+	select {
+	case h.entries <- e:
+	default:
+		slog.Warn("histórico cheio, entrada descartada", "cep", e.Cep)
+	}
+}
+
+// Close writes what is still queued and closes the database. Record must
+// not be called afterwards.
+func (h *historyStore) Close() error {
+	close(h.entries)
+	<-h.done
+	return h.db.Close()
+}
+
+// Recent returns entries newest first.
+func (h *historyStore) Recent(ctx context.Context, limit, offset int) ([]historyEntry, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT id, looked_up_at, cep, source, duration_ms, client_ip
+		FROM lookups ORDER BY id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []historyEntry{}
+	for rows.Next() {
+		var e historyEntry
+		var at string
+		if err := rows.Scan(&e.ID, &at, &e.Cep, &e.Source, &e.DurationMs, &e.ClientIP); err != nil {
+			return nil, err
+		}
+		if e.Time, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// recordHistory adds a successful lookup to the audit trail, if enabled.
+func (s *server) recordHistory(r *http.Request, code string, result resultadoAPI, d time.Duration) {
+	if s.history == nil {
+		return
+	}
+	s.history.Record(historyEntry{
+		Time:       time.Now(),
+		Cep:        code,
+		Source:     result.Origem,
+		DurationMs: d.Milliseconds(),
+		ClientIP:   clientIP(r),
+	})
+}
+
+type historyPage struct {
+	Entries    []historyEntry `json:"entries"`
+	Limit      int            `json:"limit"`
+	Offset     int            `json:"offset"`
+	NextOffset *int           `json:"next_offset,omitempty"`
+}
+
+// handleHistory lists recorded lookups, newest first, paginated with limit
+// and offset. It is an admin route: the entries carry each client's IP.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeError(w, r, http.StatusNotFound, errCodeHistoryDisabled, "Histórico desativado: inicie o servidor com -history-dsn")
+		return
+	}
+	limit, ok := queryInt(r, "limit", defaultHistoryLimit)
+	if !ok || limit <= 0 || limit > maxHistoryLimit {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("limit deve estar entre 1 e %d", maxHistoryLimit))
+		return
+	}
+	offset, ok := queryInt(r, "offset", 0)
+	if !ok || offset < 0 {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "offset não pode ser negativo")
+		return
+	}
+
+	entries, err := s.history.Recent(r.Context(), limit, offset)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erro ao consultar histórico", "err", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "Erro ao consultar histórico")
+		return
+	}
+	page := historyPage{Entries: entries, Limit: limit, Offset: offset}
+	if len(entries) == limit {
+		next := offset + limit
+		page.NextOffset = &next
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func queryInt(r *http.Request, name string, def int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader marks a response replayed from an earlier request
+	// with the same key.
+	replayedHeader = "Idempotent-Replayed"
+	// idempotencyMaxKeys bounds the memory held by stored responses; past
+	// it the oldest keys are forgotten first.
+	idempotencyMaxKeys = 10000
+)
+
+// idempotencyStore remembers, for ttl, the response to each client's
+// Idempotency-Key so a retried batch is answered without redoing its
+// lookups. Keys are scoped to the client IP, so nobody can read another
+// client's results by guessing its key. Every entry has the same TTL, so
+// insertion order is expiry order.
+type idempotencyStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order has the newest entry at the front.
+	order *list.List
+}
+
+type idempotentResponse struct {
+	key string
+	// fingerprint covers what the response depends on, so reusing a key
+	// for a different request is caught.
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	// done is false while the first request is still running.
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// middleware replays the stored response when a request repeats a key
+// with the same body, query string and Accept header. Only 200 and 207
+// answers are stored: after a failure, a retry should really run again. A
+// request without the header passes straight through.
+func (st *idempotencyStore) middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validRequestID(key) {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("%s inválida: use até %d caracteres ASCII visíveis", idempotencyHeader, maxRequestIDLen))
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, r, err, "Corpo inválido: esperado um array JSON de CEPs")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		h.Write([]byte(r.Header.Get("Accept")))
+		h.Write([]byte{0})
+		h.Write([]byte(r.URL.RawQuery))
+		h.Write([]byte{0})
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		h.Sum(fingerprint[:0])
+
+		prior, entry := st.claim(clientIP(r)+" "+key, fingerprint)
+		switch {
+		case entry != nil:
+		case prior.fingerprint != fingerprint:
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeIdempotencyReused, fmt.Sprintf("%s já usada com outra requisição", idempotencyHeader))
+			return
+		case !prior.done:
+			writeError(w, r, http.StatusConflict, errCodeIdempotencyInProgress, fmt.Sprintf("Requisição com esta %s ainda em andamento", idempotencyHeader))
+			return
+		default:
+			w.Header().Set("Content-Type", prior.contentType)
+			w.Header().Set(replayedHeader, "true")
+			w.WriteHeader(prior.status)
+			w.Write(prior.body)
+			return
+		}
+
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		stored := false
+		defer func() {
+			if !stored {
+				st.release(entry)
+			}
+		}()
+		next(rec, r)
+		if rec.status == http.StatusOK || rec.status == http.StatusMultiStatus {
+			st.complete(entry, rec.status, w.Header().Get("Content-Type"), rec.body.Bytes())
+			stored = true
+		}
+	}
+}
+
+// claim returns a copy of the live entry for key as prior or, when there
+// is none, registers a new in-flight entry for the caller to run and then
+// complete or release.
+func (st *idempotencyStore) claim(key string, fingerprint [sha256.Size]byte) (prior idempotentResponse, entry *idempotentResponse) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	for back := st.order.Back(); back != nil && (now.After(back.Value.(*idempotentResponse).expires) || st.order.Len() >= idempotencyMaxKeys); back = st.order.Back() {
+		st.remove(back)
+	}
+	if elem, ok := st.entries[key]; ok {
+		return *elem.Value.(*idempotentResponse), nil
+	}
+	entry = &idempotentResponse{key: key, fingerprint: fingerprint, expires: now.Add(st.ttl)}
+	st.entries[key] = st.order.PushFront(entry)
+	return idempotentResponse{}, entry
+}
+
+func (st *idempotencyStore) complete(entry *idempotentResponse, status int, contentType string, body []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	entry.done, entry.status, entry.contentType, entry.body = true, status, contentType, body
+}
+
+// release forgets an entry whose request didn't produce a response worth
+// replaying, so the key can be retried.
+func (st *idempotencyStore) release(entry *idempotentResponse) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if elem, ok := st.entries[entry.key]; ok && elem.Value == entry {
+		st.remove(elem)
+	}
+}
+
+func (st *idempotencyStore) remove(elem *list.Element) {
+	st.order.Remove(elem)
+	delete(st.entries, elem.Value.(*idempotentResponse).key)
+}
+
+// bodyRecorder passes a response through while keeping a copy of its
+// status and body, for replaying it. Flush is forwarded so streamed
+// batches still stream.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bodyRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bodyRecorder) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *bodyRecorder) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *bodyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
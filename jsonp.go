@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+)
+
+// jsonpCallback accepts plain JavaScript identifiers, optionally dotted
+// (e.g. app.onCep), and nothing else, so the callback can't inject script.
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+const maxCallbackLen = 64
+
+func validCallback(name string) bool {
+	return len(name) <= maxCallbackLen && jsonpCallback.MatchString(name)
+}
+
+// writeJSONP writes v as a call to callback. The leading comment keeps the
+// body from starting with attacker-chosen bytes, which defeats content
+// sniffing tricks like Rosetta Flash.
+func writeJSONP(w http.ResponseWriter, callback string, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, err = w.Write([]byte("/**/" + callback + "(" + string(b) + ");"))
+	return err
+}
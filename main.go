@@ -2,163 +2,321 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"strings"
-	"time"
-)
-
-type AddressBrasil struct {
-	Cep          string `json:"cep"`
-	State        string `json:"state"`
-	City         string `json:"city"`
-	Neighborhood string `json:"neighborhood"`
-	Street       string `json:"street"`
-	Service      string `json:"-"`
-}
-
-type AddressViaCep struct {
-	Cep        string `json:"cep"`
-	Uf         string `json:"uf"`
-	Localidade string `json:"localidade"`
-	Bairro     string `json:"bairro"`
-	Logradouro string `json:"logradouro"`
-	Service    string `json:"-"`
-}
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 
-type resultadoAPI struct {
-	Origem string      `json:"origem"`
-	Data   interface{} `json:"data"`
-	Err    error       `json:"erro,omitempty"`
-}
+	"github.com/HenriqueOtsuka/multithread/cep"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
 
-func fetchFromBrasilAPI(ctx context.Context, cep string) (AddressBrasil, error) {
-	start := time.Now()
-	url := fmt.Sprintf("https://brasilapi.com.br/api/cep/v1/%s", cep)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+func main() {
+	cfg, err := parseConfig(os.Args[1:])
 	if err != nil {
-		err := fmt.Errorf("error creating request: %v", err)
-		return AddressBrasil{}, err
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		slog.Error("configuração inválida", "err", err)
+		os.Exit(2)
 	}
+	if cfg.showVersion {
+		fmt.Println(currentBuild())
+		return
+	}
+	slog.SetDefault(newLogger(os.Stderr, cfg.logFormat, cfg.logLevel))
 
-	resp, err := http.DefaultClient.Do(req)
+	s, err := newServer(cfg)
 	if err != nil {
-		return AddressBrasil{}, err
+		slog.Error("erro ao iniciar", "err", err)
+		os.Exit(1)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return AddressBrasil{}, fmt.Errorf("requisição falhou: %s", resp.Status)
+	if cfg.cep != "" {
+		os.Exit(runLookup(s, cfg.cep))
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return AddressBrasil{}, fmt.Errorf("error reading response: %v", err)
+	if cfg.warmup {
+		warmup(s, cfg.warmupTimeout)
 	}
-	var address AddressBrasil
-	if err := json.Unmarshal(body, &address); err != nil {
-		return AddressBrasil{}, fmt.Errorf("error reading response: %v", err)
+	if cfg.preload != "" {
+		if err := preload(s, cfg.preload, cfg.preloadTimeout, cfg.preloadMaxFailed); err != nil {
+			slog.Error("erro na pré-carga do cache", "err", err)
+			os.Exit(1)
+		}
 	}
 
-	duration := time.Since(start)
-	fmt.Println("Tempo de resposta BrasilAPI:", duration)
-	return address, nil
-}
-
-func fetchFromViaCep(ctx context.Context, cep string) (AddressViaCep, error) {
-	start := time.Now()
-	url := fmt.Sprintf("https://viacep.com.br/ws/%s/json/", cep)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		err := fmt.Errorf("error creating request: %v", err)
-		return AddressViaCep{}, err
+	handler := requestID(recoverPanics(cors(cfg.corsOrigins, timeoutBudget(cfg.minTimeout, cfg.maxTimeout, s.compressed(cfg.gzipMinSize)))))
+	switch cfg.accessLog {
+	case "":
+	case "-":
+		handler = accessLog(os.Stdout, cfg.accessLogFormat, handler)
+	default:
+		f, err := os.OpenFile(cfg.accessLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			slog.Error("erro ao abrir access log", "err", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		handler = accessLog(f, cfg.accessLogFormat, handler)
 	}
+	handler = realIP(cfg.trustedProxies, handler)
 
-	resp, err := http.DefaultClient.Do(req)
+	tlsConfig, err := serverTLS(cfg.tlsCert, cfg.tlsKey, cfg.tlsClientCA)
 	if err != nil {
-		return AddressViaCep{}, err
+		slog.Error("erro ao carregar TLS", "err", err)
+		os.Exit(1)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return AddressViaCep{}, fmt.Errorf("error reading response: %v", err)
-	}
-	var address AddressViaCep
-	if err := json.Unmarshal(body, &address); err != nil {
-		return AddressViaCep{}, fmt.Errorf("error reading response: %v", err)
+	srv := &http.Server{
+		Addr:              cfg.addr,
+		TLSConfig:         tlsConfig,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
 	}
-	duration := time.Since(start)
-	fmt.Println("Tempo de resposta ViaCep:", duration)
-	return address, nil
+	srv.RegisterOnShutdown(s.closeStreams)
 
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func handleCEP(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) != 3 || parts[2] == "" {
-		http.Error(w, "Uso correto: /cep/{cep}", http.StatusBadRequest)
-		return
-	}
-	cep := parts[2]
-	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
-	resChan := make(chan resultadoAPI, 2)
+	errCh := make(chan error, 1)
 	go func() {
-		brasil, errBrasil := fetchFromBrasilAPI(ctx, cep)
-		if errBrasil != nil {
-			resChan <- resultadoAPI{Origem: "brasilapi", Data: nil, Err: errBrasil}
+		slog.Info("servidor escutando", "addr", srv.Addr, "tls", tlsConfig != nil, "mtls", cfg.tlsClientCA != "")
+		if tlsConfig != nil {
+			errCh <- srv.ListenAndServeTLS("", "")
 			return
 		}
-		resChan <- resultadoAPI{Origem: "brasilapi", Data: brasil}
+		errCh <- srv.ListenAndServe()
 	}()
-	go func() {
-		viacep, errVia := fetchFromViaCep(ctx, cep)
-		if errVia != nil {
-			resChan <- resultadoAPI{Origem: "viacep", Data: nil, Err: errVia}
-			return
+
+	select {
+	case err := <-errCh:
+		slog.Error("servidor encerrado", "err", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("encerrando servidor", "grace_period", cfg.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("erro ao encerrar servidor", "err", err)
+		os.Exit(1)
+	}
+	if !s.waitStreams(shutdownCtx) {
+		slog.Error("erro ao encerrar servidor", "err", "websockets ainda abertos ao fim do prazo")
+		os.Exit(1)
+	}
+	if s.history != nil {
+		if err := s.history.Close(); err != nil {
+			slog.Error("erro ao fechar histórico", "err", err)
 		}
-		resChan <- resultadoAPI{Origem: "viacep", Data: viacep}
-	}()
+	}
+	if c, ok := s.cache.(io.Closer); ok {
+		c.Close()
+	}
+	slog.Info("servidor encerrado")
+}
 
-	result := <-resChan
-	cancel()
-	if result.Err != nil {
-		if errors.Is(result.Err, context.DeadlineExceeded) {
-			http.Error(w, "Erro: tempo de espera excedido", http.StatusRequestTimeout)
-			return
+// providerNames lists the providers this server registers; correios only
+// when its credentials are configured.
+var providerNames = []string{"brasilapi", "viacep", "opencep", "postmon", "correios"}
+
+func newServer(cfg config) (*server, error) {
+	client := newHTTPClient(cfg.proxy)
+	if cfg.proxy != nil {
+		slog.Info("provedores consultados via proxy", "proxy", cfg.proxy.Redacted())
+	}
+	viaCep := cep.ViaCep{Client: client, BaseURL: cfg.viaCepURL, UserAgent: cfg.userAgent, MaxBodySize: cfg.maxBodySize, Strict: cfg.strictUpstream}
+	providers := []cep.Provider{
+		cep.BrasilAPI{Client: client, BaseURL: cfg.brasilAPIURL, UserAgent: cfg.userAgent, MaxBodySize: cfg.maxBodySize, Strict: cfg.strictUpstream},
+		viaCep,
+		cep.OpenCep{Client: client, BaseURL: cfg.openCepURL, UserAgent: cfg.userAgent, MaxBodySize: cfg.maxBodySize, Strict: cfg.strictUpstream},
+		cep.Postmon{Client: client, BaseURL: cfg.postmonURL, UserAgent: cfg.userAgent, MaxBodySize: cfg.maxBodySize, Strict: cfg.strictUpstream},
+	}
+	if cfg.correiosUser != "" {
+		providers = append(providers, &cep.Correios{
+			Client:      client,
+			BaseURL:     cfg.correiosURL,
+			UserAgent:   cfg.userAgent,
+			MaxBodySize: cfg.maxBodySize,
+			Username:    cfg.correiosUser,
+			AccessCode:  cfg.correiosAccessCode,
+			PostingCard: cfg.correiosPostingCard,
+		})
+	}
+	configs := map[string]providerConfig{
+		"brasilapi": {baseURL: cfg.brasilAPIURL},
+		"viacep":    {baseURL: cfg.viaCepURL},
+		"opencep":   {baseURL: cfg.openCepURL},
+		"postmon":   {baseURL: cfg.postmonURL},
+		"correios":  {baseURL: cfg.correiosURL},
+	}
+	var searcher addressSearcher = viaCep
+	if cfg.mock {
+		providers = []cep.Provider{mockProvider{}}
+		configs = map[string]providerConfig{}
+		searcher = mockProvider{}
+	}
+	stats := newProviderStats()
+	resolver := &cep.Resolver{
+		Providers:          providers,
+		Timeout:            cfg.timeout,
+		InFlight:           cep.NewSemaphore(cfg.maxUpstream),
+		Preferred:          cfg.preferred,
+		PreferenceWindow:   cfg.preferenceWindow,
+		Primary:            cfg.primary,
+		HeadStart:          cfg.headStart,
+		Required:           cfg.requiredFields,
+		CompletenessWindow: cfg.completenessWindow,
+		Switches:           cep.NewSwitches(),
+		Logger:             slog.Default(),
+		Hooks:              combineHooks(metricsHooks(), stats.hooks()),
+	}
+	// Under -mock the fake provider stands in for all of them, so -providers
+	// has nothing to pick from.
+	if len(cfg.providers) > 0 && !cfg.mock {
+		for _, p := range providers {
+			resolver.Switches.Set(p.Name(), slices.Contains(cfg.providers, p.Name()))
+		}
+	}
+	if cfg.fanOut > 0 {
+		resolver.FanOut = cep.NewRanking(cfg.fanOut)
+	}
+	if cfg.healthWindow > 0 {
+		resolver.Health = cep.NewHealth(cfg.healthWindow, cfg.healthThreshold, cfg.healthMinProbe)
+	}
+	for i, p := range resolver.Providers {
+		c := configs[p.Name()]
+		c.timeout = cfg.providerTimeouts[p.Name()]
+		c.retryOnEmpty = slices.Contains(cfg.retryOnEmpty, p.Name())
+		configs[p.Name()] = c
+		if c.retryOnEmpty {
+			p = cep.WithRetryOnEmpty(p, slog.Default())
+		}
+		resolver.Providers[i] = cep.Coalesce(cep.WithTimeout(cep.WithRetry(p, cfg.retries), cfg.providerTimeouts[p.Name()]))
+	}
+	if cfg.breakerThreshold > 0 {
+		resolver.Breakers = make(map[string]*cep.Breaker, len(resolver.Providers))
+		for _, p := range resolver.Providers {
+			resolver.Breakers[p.Name()] = newMeteredBreaker(p.Name(), cfg.breakerThreshold, cfg.breakerCooldown)
 		}
-		http.Error(w, "Erro: "+result.Err.Error(), http.StatusInternalServerError)
-		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	switch result.Origem {
-	case "viacep":
-		if data, ok := result.Data.(AddressViaCep); ok {
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(resultadoAPI{
-				Origem: "viacep",
-				Data:   data,
-			})
-			return
+	s := &server{
+		resolver:         resolver,
+		searcher:         searcher,
+		stats:            stats,
+		providerConfigs:  configs,
+		streamOptions:    streamAcceptOptions(cfg.corsOrigins),
+		stopping:         make(chan struct{}),
+		timeout:          cfg.timeout,
+		batchMax:         cfg.batchMax,
+		batchMaxBytes:    cfg.batchMaxBytes,
+		batchConcurrency: cfg.batchConcurrency,
+		nearbyMaxRadius:  cfg.nearbyMaxRadius,
+		nearbyMaxResults: cfg.nearbyMaxResults,
+		nearbyProbes:     cfg.nearbyProbes,
+		strictAccept:     cfg.strictAccept,
+		jsonp:            cfg.jsonp,
+		debugRaw:         cfg.debugRaw,
+		adminToken:       cfg.adminToken,
+		serveStale:       cfg.serveStale,
+		maxAge:           cfg.maxAge,
+	}
+	switch {
+	case cfg.cacheTTL == 0:
+	case cfg.cacheBackend == cacheBackendRedis:
+		cache, err := newRedisCache(cfg.redisURL, cfg.serveStale)
+		if err != nil {
+			return nil, fmt.Errorf("redis-url inválida: %w", err)
 		}
-	case "brasilapi":
-		if data, ok := result.Data.(AddressBrasil); ok {
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(resultadoAPI{
-				Origem: "brasilapi",
-				Data:   data,
-			})
-			return
+		s.cache, s.cacheTTL = cache, cfg.cacheTTL
+	case cfg.cacheSize > 0:
+		s.cache, s.cacheTTL = newMemoryCache(cfg.cacheSize), cfg.cacheTTL
+	}
+	if cfg.idempotencyTTL > 0 {
+		s.idempotency = newIdempotencyStore(cfg.idempotencyTTL)
+	}
+	if cfg.rateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.rateLimit, cfg.rateBurst, cfg.rateClients)
+	}
+	if cfg.historyDSN != "" {
+		history, err := openHistory(cfg.historyDSN)
+		if err != nil {
+			return nil, err
+		}
+		s.history = history
+		if cfg.adminToken == "" {
+			slog.Warn("histórico gravado, mas GET /history só é servido com -admin-token")
 		}
-	default:
-		http.Error(w, "Erro interno: tipo inesperado de resposta", http.StatusInternalServerError)
 	}
+	return s, nil
 }
 
-func main() {
-	http.HandleFunc("/cep/", handleCEP)
-	http.ListenAndServe(":8080", nil)
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	// Every route names its methods, so the mux answers the others with a
+	// 405 and an Allow header; GET routes take HEAD too, which runs the
+	// handler and drops the body.
+	mux.Handle("GET /cep", s.rateLimited(s.handleCEP))
+	mux.Handle("GET /cep/", s.rateLimited(s.handleCEP))
+	mux.Handle("POST /cep", limitBody(cepBodyMax, s.rateLimited(s.handleCEPBody)))
+	mux.Handle("POST /cep/batch", limitBody(s.batchMaxBytes, s.rateLimited(s.idempotent(s.handleBatch))))
+	mux.Handle("GET /cep/{cep}/compare", s.rateLimited(s.handleCompare))
+	mux.Handle("GET /cep/{cep}/merge", s.rateLimited(s.handleMerge))
+	mux.Handle("GET /cep/{cep}/nearby", s.rateLimited(s.handleNearby))
+	mux.Handle("GET /ws/batch", s.rateLimited(s.handleBatchStream))
+	mux.Handle("GET /address", s.rateLimited(s.handleAddress))
+	mux.Handle("GET /distance", s.rateLimited(s.handleDistance))
+	mux.HandleFunc("GET /health", handleHealth)
+	mux.HandleFunc("GET /version", handleVersion)
+	mux.HandleFunc("GET /ready", s.handleReady)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /stats", s.handleStats)
+	mux.HandleFunc("GET /providers", s.handleProviders)
+	mux.HandleFunc("GET /states/{uf}/ranges", s.handleRanges)
+	if s.adminToken != "" {
+		mux.Handle("GET /history", s.admin(s.handleHistory))
+		mux.Handle("DELETE /cache", s.admin(s.handleCacheClear))
+		mux.Handle("DELETE /cache/{cep}", s.admin(s.handleCacheDelete))
+		mux.Handle("POST /cache/warm", limitBody(s.batchMaxBytes, s.admin(s.handleCacheWarm)))
+		mux.Handle("PUT /providers/{name}", s.admin(s.handleProviderSwitch))
+	}
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+	mux.HandleFunc("GET /docs", handleDocs)
+	return methodErrors(mux)
+}
+
+// idempotent replays earlier responses to repeated Idempotency-Keys, when
+// enabled.
+func (s *server) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	if s.idempotency == nil {
+		return h
+	}
+	return s.idempotency.middleware(h)
+}
+
+// rateLimited applies the per-client limiter, when enabled, to routes that
+// reach the upstream providers.
+func (s *server) rateLimited(h http.HandlerFunc) http.Handler {
+	if s.limiter == nil {
+		return h
+	}
+	return s.limiter.middleware(h)
+}
+
+func (s *server) compressed(minSize int) http.Handler {
+	if minSize < 0 {
+		return s.routes()
+	}
+	return gzipResponses(minSize, s.routes())
 }
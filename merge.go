@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+type mergeResponse struct {
+	Origem     string            `json:"origem"`
+	Data       cep.Address       `json:"data"`
+	Sources    map[string]string `json:"sources"`
+	DurationMs int64             `json:"duracao_ms"`
+}
+
+// handleMerge waits for every provider, like handleCompare, and fills the
+// gaps in the first answer with fields from the others. It trades the
+// race's latency for completeness, so it lives on its own route instead of
+// changing /cep/{cep}.
+func (s *server) handleMerge(w http.ResponseWriter, r *http.Request) {
+	code, err := cep.Normalize(r.PathValue("cep"))
+	if err != nil {
+		status, errCode := normalizeError(err)
+		writeError(w, r, status, errCode, err.Error())
+		return
+	}
+
+	results := s.resolver.All(r.Context(), code)
+	merged, ok := cep.Merge(results)
+	if !ok {
+		err := error(cep.ErrNoProviders)
+		if len(results) > 0 {
+			lookupErr := &cep.LookupError{}
+			for _, result := range results {
+				lookupErr.Failures = append(lookupErr.Failures, cep.Failure{Provider: result.Provider, Err: result.Err})
+			}
+			err = lookupErr
+		}
+		slog.InfoContext(r.Context(), "consulta combinada falhou", "cep", code, "err", err)
+		status, errCode, message := lookupError(r.Context(), err)
+		writeError(w, r, status, errCode, message)
+		return
+	}
+
+	var slowest int64
+	for _, result := range results {
+		slowest = max(slowest, result.Duration.Milliseconds())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mergeResponse{Origem: merged.Address.Source, Data: merged.Address, Sources: merged.Sources, DurationMs: slowest})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errorMessages is the catalog of error messages by code and language.
+// Handlers still pass a Portuguese message with the specifics of each
+// failure, which Portuguese clients get as is; the catalog's Portuguese
+// text stands in when there are none, and English clients always get the
+// catalog's English one.
+var errorMessages = map[string]map[string]string{
+	errCodeBadRequest:            {langPT: "Requisição inválida", langEN: "Invalid request"},
+	errCodeInvalidCEP:            {langPT: "CEP inválido: use 12345678 ou 12345-678", langEN: "Invalid CEP: use 12345678 or 12345-678"},
+	errCodeImpossibleCEP:         {langPT: "CEP fora das faixas existentes", langEN: "CEP outside the ranges in use"},
+	errCodeInvalidAddress:        {langPT: "Endereço inválido", langEN: "Invalid address"},
+	errCodeNotFound:              {langPT: "CEP não encontrado", langEN: "CEP not found"},
+	errCodeInvalidCallback:       {langPT: "callback inválido: use um identificador JavaScript", langEN: "Invalid callback: use a JavaScript identifier"},
+	errCodeInvalidFields:         {langPT: "Campo desconhecido em fields", langEN: "Unknown field in fields"},
+	errCodeInvalidLang:           {langPT: "lang inválido: use en ou pt", langEN: "Invalid lang: use en or pt"},
+	errCodeProviderNotFound:      {langPT: "Provedor não registrado", langEN: "Provider not registered"},
+	errCodeNoCoordinates:         {langPT: "Nenhum provedor informa as coordenadas do CEP", langEN: "No provider supplies the CEP's coordinates"},
+	errCodeNotAcceptable:         {langPT: "Formato não suportado: use application/json ou application/xml", langEN: "Unsupported format: use application/json or application/xml"},
+	errCodeMethodNotAllowed:      {langPT: "Método não permitido", langEN: "Method not allowed"},
+	errCodeBatchTooLarge:         {langPT: "Lote excede o limite de CEPs", langEN: "Batch exceeds the CEP limit"},
+	errCodeBodyTooLarge:          {langPT: "Corpo excede o limite de tamanho", langEN: "Request body exceeds the size limit"},
+	errCodeIdempotencyReused:     {langPT: "Idempotency-Key já usada com outra requisição", langEN: "Idempotency-Key already used with a different request"},
+	errCodeIdempotencyInProgress: {langPT: "Requisição com esta Idempotency-Key ainda em andamento", langEN: "A request with this Idempotency-Key is still in progress"},
+	errCodeRateLimited:           {langPT: "Limite de requisições excedido, tente novamente mais tarde", langEN: "Rate limit exceeded, try again later"},
+	errCodeTimeout:               {langPT: "Os provedores não responderam a tempo", langEN: "The providers did not answer in time"},
+	errCodeRequestTimeout:        {langPT: "Tempo da requisição esgotado", langEN: "Request timed out"},
+	errCodeClientClosed:          {langPT: "Requisição cancelada pelo cliente", langEN: "Request cancelled by the client"},
+	errCodeUnavailable:           {langPT: "Nenhum provedor disponível no momento", langEN: "No provider available at the moment"},
+	errCodeUpstream:              {langPT: "Todos os provedores falharam", langEN: "Every provider failed"},
+	errCodeHistoryDisabled:       {langPT: "Histórico desativado: inicie o servidor com -history-dsn", langEN: "History disabled: start the server with -history-dsn"},
+	errCodeUnauthorized:          {langPT: "Token de administração ausente ou inválido", langEN: "Missing or invalid admin token"},
+	errCodeShuttingDown:          {langPT: "Servidor encerrando", langEN: "Server shutting down"},
+	errCodeInternal:              {langPT: "Erro interno", langEN: "Internal error"},
+}
+
+// contentLanguage is the Content-Language sent with each language.
+var contentLanguage = map[string]string{langPT: "pt-BR", langEN: "en"}
+
+// localize picks the message for code in lang: message itself in
+// Portuguese, unless empty, and the catalog's text otherwise. A message
+// made of the catalog's Portuguese text, ": " and a detail, e.g. each
+// provider's failure, keeps the detail in every language; only the fixed
+// part is translated.
+func localize(code, lang, message string) string {
+	if lang == langPT && message != "" {
+		return message
+	}
+	text, ok := errorMessages[code][lang]
+	if !ok {
+		return message
+	}
+	if detail, found := strings.CutPrefix(message, errorMessages[code][langPT]+": "); found {
+		return text + ": " + detail
+	}
+	return text
+}
+
+// negotiateLanguage picks the error language from an Accept-Language
+// header, honoring q-values: any pt or en tag selects that language, and
+// anything else, or a missing header, means Portuguese.
+func negotiateLanguage(accept string) string {
+	best, bestQ := langPT, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if (primary == langPT || primary == langEN) && q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// errorLanguage negotiates the language of r's error messages and marks
+// the response accordingly.
+func errorLanguage(w http.ResponseWriter, r *http.Request) string {
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", contentLanguage[lang])
+	w.Header().Add("Vary", "Accept-Language")
+	return lang
+}
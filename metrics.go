@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	lookupsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cep_lookups_total",
+		Help: "Total de consultas de CEP recebidas.",
+	})
+	lookupDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cep_lookup_duration_seconds",
+		Help:    "Latência ponta a ponta das consultas de CEP.",
+		Buckets: prometheus.DefBuckets,
+	})
+	providerWins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cep_provider_wins_total",
+		Help: "Consultas vencidas por cada provedor.",
+	}, []string{"provider"})
+	providerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cep_provider_errors_total",
+		Help: "Falhas de cada provedor.",
+	}, []string{"provider"})
+	providerLostRaces = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cep_provider_lost_races_total",
+		Help: "Chamadas de cada provedor canceladas porque outro já tinha vencido; não contam como falha.",
+	}, []string{"provider"})
+	providerAborted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cep_provider_aborted_total",
+		Help: "Chamadas de cada provedor canceladas porque o cliente desistiu da consulta; não contam como falha.",
+	}, []string{"provider"})
+	providerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cep_provider_duration_seconds",
+		Help:    "Tempo de resposta de cada provedor, sem as chamadas canceladas.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cep_cache_hits_total",
+		Help: "Consultas respondidas pelo cache em memória.",
+	})
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cep_cache_misses_total",
+		Help: "Consultas que não estavam no cache em memória, ou tinham expirado.",
+	})
+	cacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cep_cache_evictions_total",
+		Help: "Entradas descartadas do cache em memória por falta de espaço.",
+	})
+	providerCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cep_provider_circuit_state",
+		Help: "Estado do circuit breaker de cada provedor (0 fechado, 1 aberto, 2 meio aberto).",
+	}, []string{"provider"})
+)
+
+// metricsHooks feeds the resolver's provider calls into the metrics above.
+// Like providerStats it leaves cancelled calls out of the errors and the
+// latency: lost races and calls whose caller gave up have counters of
+// their own.
+func metricsHooks() cep.Hooks {
+	return cep.Hooks{
+		ProviderDone: func(provider string, d time.Duration, err error) {
+			switch {
+			case errors.Is(err, cep.ErrLostRace):
+				providerLostRaces.WithLabelValues(provider).Inc()
+				return
+			case errors.Is(err, context.Canceled):
+				providerAborted.WithLabelValues(provider).Inc()
+				return
+			case err != nil:
+				providerErrors.WithLabelValues(provider).Inc()
+			}
+			providerDuration.WithLabelValues(provider).Observe(d.Seconds())
+		},
+		Won: func(provider string) {
+			providerWins.WithLabelValues(provider).Inc()
+		},
+	}
+}
+
+func newMeteredBreaker(provider string, threshold int, cooldown time.Duration) *cep.Breaker {
+	b := cep.NewBreaker(threshold, cooldown)
+	gauge := providerCircuitState.WithLabelValues(provider)
+	gauge.Set(float64(cep.BreakerClosed))
+	b.OnStateChange = func(state cep.BreakerState) { gauge.Set(float64(state)) }
+	return b
+}
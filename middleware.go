@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+const maxRequestIDLen = 128
+
+// requestID propagates the caller's X-Request-ID, or a fresh UUID when it is
+// missing or unusable, through the request context and back in the response.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID keeps client-supplied IDs short and printable so they
+// cannot be used to forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// limitBody caps the request body at n bytes. Reading past the cap fails
+// with an *http.MaxBytesError, which the handler turns into a 413, and
+// closes the connection afterwards.
+func limitBody(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodErrors gives the 405 the mux answers for a method a route doesn't
+// take, Allow header included, the JSON error body of every other error
+// instead of the mux's plain text.
+func methodErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&methodErrorWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// methodErrorWriter swaps a 405 for writeError's and drops the body that
+// follows it.
+type methodErrorWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	replaced bool
+}
+
+func (w *methodErrorWriter) WriteHeader(code int) {
+	if code != http.StatusMethodNotAllowed || w.replaced {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.replaced = true
+	writeError(w.ResponseWriter, w.r, code, errCodeMethodNotAllowed, fmt.Sprintf("Método %s não permitido: use %s", w.r.Method, w.Header().Get("Allow")))
+}
+
+func (w *methodErrorWriter) Write(p []byte) (int, error) {
+	if w.replaced {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *methodErrorWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *methodErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// timeoutHeader lets a client pick its own lookup timeout, in
+// milliseconds, within the server's bounds.
+const timeoutHeader = "X-Timeout-Ms"
+
+// timeoutBudget applies the client's X-Timeout-Ms to the lookups of the
+// request, clamped to [lo, hi] so no client can hold a lookup open for
+// longer than hi or ask for one too short to ever succeed. The timeout
+// applied is echoed in the response header. A missing or malformed value
+// leaves the server's -timeout in place.
+func timeoutBudget(lo, hi time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms, err := strconv.ParseInt(r.Header.Get(timeoutHeader), 10, 64)
+		if err != nil || ms <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := hi
+		if ms < hi.Milliseconds() {
+			d = max(lo, time.Duration(ms)*time.Millisecond)
+		}
+		w.Header().Set(timeoutHeader, strconv.FormatInt(d.Milliseconds(), 10))
+		next.ServeHTTP(w, r.WithContext(cep.WithLookupTimeout(r.Context(), d)))
+	})
+}
+
+// recoverPanics turns a panic in a handler into a logged 500 instead of a
+// dropped connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			slog.ErrorContext(r.Context(), "panic em handler", "method", r.Method, "path", r.URL.Path, "panic", err, "stack", string(debug.Stack()))
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "Erro interno")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors allows browser clients from the configured origins. With no origins
+// configured it returns next untouched, so no CORS headers are sent.
+func cors(origins []string, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	allowAll := slices.Contains(origins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := allowAll || slices.Contains(origins, origin)
+		if allowed {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				// PUT, DELETE and Authorization are for the admin routes.
+				h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				h.Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, "+timeoutHeader+", "+idempotencyHeader)
+				h.Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// mockDelay is how long the mock provider takes to answer, enough for
+// timeouts and cancellation to behave as they do against real upstreams.
+const mockDelay = 20 * time.Millisecond
+
+// mockRegions maps the first digit of a CEP to a state, some of its
+// cities and the coordinates of the region's main city, following the real
+// CEP regions.
+var mockRegions = [10]struct {
+	state    string
+	cities   []string
+	lat, lng float64
+}{
+	{"SP", []string{"São Paulo", "Guarulhos", "Osasco"}, -23.55, -46.63},
+	{"SP", []string{"Campinas", "Santos", "Sorocaba"}, -22.91, -47.06},
+	{"RJ", []string{"Rio de Janeiro", "Niterói", "Vitória"}, -22.91, -43.21},
+	{"MG", []string{"Belo Horizonte", "Uberlândia", "Juiz de Fora"}, -19.92, -43.94},
+	{"BA", []string{"Salvador", "Feira de Santana", "Aracaju"}, -12.97, -38.50},
+	{"PE", []string{"Recife", "João Pessoa", "Maceió"}, -8.05, -34.88},
+	{"CE", []string{"Fortaleza", "Belém", "Manaus"}, -3.73, -38.52},
+	{"DF", []string{"Brasília", "Goiânia", "Cuiabá"}, -15.79, -47.88},
+	{"PR", []string{"Curitiba", "Londrina", "Florianópolis"}, -25.43, -49.27},
+	{"RS", []string{"Porto Alegre", "Caxias do Sul", "Pelotas"}, -30.03, -51.23},
+}
+
+var (
+	mockNeighborhoods = []string{"Centro", "Jardim América", "Vila Nova", "Boa Vista", "Santa Cecília"}
+	mockStreets       = []string{"Rua das Flores", "Avenida Brasil", "Rua XV de Novembro", "Rua São João", "Avenida Paulista"}
+)
+
+// mockProvider answers with fake addresses derived from the CEP digits, so
+// the same CEP always gets the same answer and nothing touches the network.
+// CEPs ending in 999 are reported as not found, and those with an odd last
+// digit come without coordinates, as many real answers do.
+type mockProvider struct{}
+
+func (mockProvider) Name() string { return "mock" }
+
+func (mockProvider) Ping(context.Context) error { return nil }
+
+func (mockProvider) Lookup(ctx context.Context, code string) (cep.Address, error) {
+	if err := mockWait(ctx); err != nil {
+		return cep.Address{}, err
+	}
+	if strings.HasSuffix(code, "999") {
+		return cep.Address{}, cep.ErrNotFound
+	}
+	return mockAddress(code), nil
+}
+
+// Search makes mockProvider an addressSearcher, returning a few addresses
+// on the requested street.
+func (mockProvider) Search(ctx context.Context, uf, city, street string) ([]cep.Address, error) {
+	if err := mockWait(ctx); err != nil {
+		return nil, err
+	}
+	seed := 0
+	for _, r := range uf + city + street {
+		seed = (seed*31 + int(r)) % 1000000
+	}
+	addresses := make([]cep.Address, 3)
+	for i := range addresses {
+		code := fmt.Sprintf("%05d%03d", seed%100000, i*100)
+		addresses[i] = mockAddress(code)
+		addresses[i].State, addresses[i].City, addresses[i].Street = uf, city, street
+	}
+	return addresses, nil
+}
+
+func mockWait(ctx context.Context) error {
+	timer := time.NewTimer(mockDelay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func mockAddress(code string) cep.Address {
+	region := mockRegions[code[0]-'0']
+	address := cep.Address{
+		Cep:          code[:5] + "-" + code[5:],
+		State:        region.state,
+		City:         region.cities[int(code[1]-'0')%len(region.cities)],
+		Neighborhood: mockNeighborhoods[int(code[2]-'0')%len(mockNeighborhoods)],
+		Street:       mockStreets[int(code[3]-'0')%len(mockStreets)],
+		Source:       "mock",
+	}
+	if (code[7]-'0')%2 == 0 {
+		// Spread the CEPs of a region over roughly 20 km around its city.
+		lat := math.Round((region.lat+float64(code[4]-'0')/50-0.09)*1e4) / 1e4
+		lng := math.Round((region.lng+float64(code[5]-'0')/50-0.09)*1e4) / 1e4
+		address.Lat, address.Lng = &lat, &lng
+	}
+	return address
+}
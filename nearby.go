@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+type nearbyCEP struct {
+	cep.Address
+	DistanceKm float64 `json:"distance_km"`
+}
+
+type nearbyResponse struct {
+	From     cep.Address `json:"from"`
+	RadiusKm float64     `json:"radius_km"`
+	Nearby   []nearbyCEP `json:"nearby"`
+	// WithoutCoordinates counts the probes answered without lat and lng,
+	// whose distance is unknown; Skipped the ones left out because they
+	// would have raced the providers after the client's rate limit ran
+	// out.
+	WithoutCoordinates int `json:"without_coordinates"`
+	Skipped            int `json:"skipped"`
+}
+
+// handleNearby serves /cep/{cep}/nearby?radius_km=&limit= with the CEPs
+// numerically next to the given one that lie within radius_km of it,
+// closest first. There is no geographic index to query, so it probes the
+// nearbyProbes numbers around the CEP, nearest first, through the cache
+// and the race like any lookup, and keeps those whose answer carries
+// coordinates. The probes' races hold out, for the completeness window,
+// for a provider that sends lat and lng, and a cached answer without them
+// is raced again; neighbours still without coordinates are left out and
+// counted, and the seed CEP itself must have them. Each probe the cache
+// can't answer costs the client a rate-limit token, as a request would;
+// once they run out the remaining misses are skipped and counted too.
+func (s *server) handleNearby(w http.ResponseWriter, r *http.Request) {
+	code, err := cep.Normalize(r.PathValue("cep"))
+	if err != nil {
+		status, errCode := normalizeError(err)
+		writeError(w, r, status, errCode, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	radius := s.nearbyMaxRadius
+	if raw := q.Get("radius_km"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(v) || v <= 0 || v > s.nearbyMaxRadius {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("radius_km deve ser um número maior que 0 e até %g", s.nearbyMaxRadius))
+			return
+		}
+		radius = v
+	}
+	limit := s.nearbyMaxResults
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 || v > s.nearbyMaxResults {
+			writeError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("limit deve ser um inteiro entre 1 e %d", s.nearbyMaxResults))
+			return
+		}
+		limit = v
+	}
+
+	from, err := s.locate(r.Context(), code)
+	if err != nil {
+		if errors.Is(err, errNoCoordinates) {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeNoCoordinates, fmt.Sprintf("nenhum provedor informa as coordenadas do CEP %s", code))
+			return
+		}
+		slog.InfoContext(r.Context(), "consulta de CEPs próximos falhou", "cep", code, "err", err)
+		status, errCode, message := lookupError(r.Context(), err)
+		writeError(w, r, status, errCode, message)
+		return
+	}
+
+	ip := clientIP(r)
+	candidates := make(chan string)
+	var mu sync.Mutex
+	nearby := []nearbyCEP{}
+	limited, skipped, withoutCoordinates := false, 0, 0
+	probeCtx := cep.WithLookupRequired(r.Context(), "lat", "lng")
+	// charge spends a rate-limit token on a probe the cache can't answer.
+	// The first refusal stops the charging, so the count doesn't depend on
+	// tokens trickling back in mid-request.
+	charge := func() bool {
+		if s.limiter == nil {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !limited {
+			ok, _ := s.limiter.allow(ip, 1)
+			limited = !ok
+		}
+		if limited {
+			skipped++
+		}
+		return !limited
+	}
+	var wg sync.WaitGroup
+	for range s.batchConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for code := range candidates {
+				result, ok := s.cached(r.Context(), code)
+				if !ok || result.Data.Lat == nil || result.Data.Lng == nil {
+					if !charge() {
+						continue
+					}
+					result = s.race(probeCtx, code)
+				}
+				if result.Err != nil {
+					continue
+				}
+				km, ok := cep.Distance(from, result.Data)
+				if !ok {
+					mu.Lock()
+					withoutCoordinates++
+					mu.Unlock()
+					continue
+				}
+				if km > radius {
+					continue
+				}
+				result.Data.Cep = presentCEP(r, code)
+				mu.Lock()
+				nearby = append(nearby, nearbyCEP{Address: result.Data, DistanceKm: math.Round(km*1000) / 1000})
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, c := range neighbours(code, s.nearbyProbes) {
+		candidates <- c
+	}
+	close(candidates)
+	wg.Wait()
+	if r.Context().Err() != nil {
+		status, errCode, message := lookupError(r.Context(), r.Context().Err())
+		writeError(w, r, status, errCode, message)
+		return
+	}
+
+	slices.SortFunc(nearby, func(a, b nearbyCEP) int {
+		return cmp.Or(cmp.Compare(a.DistanceKm, b.DistanceKm), cmp.Compare(a.Cep, b.Cep))
+	})
+	if len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	from.Cep = presentCEP(r, code)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nearbyResponse{From: from, RadiusKm: radius, Nearby: nearby, WithoutCoordinates: withoutCoordinates, Skipped: skipped})
+}
+
+// neighbours returns up to n CEPs within n of code, alternating above and
+// below it so the numerically nearest come first. Numbers outside the
+// ranges the Correios assign are skipped.
+func neighbours(code string, n int) []string {
+	seed, _ := strconv.Atoi(code)
+	var out []string
+	for step := 1; step <= n && len(out) < n; step++ {
+		for _, v := range []int{seed + step, seed - step} {
+			if v < 0 || v > 99999999 || len(out) == n {
+				continue
+			}
+			if c, err := cep.Normalize(fmt.Sprintf("%08d", v)); err == nil {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// providerConfig is what newServer knew about a provider when it built it.
+type providerConfig struct {
+	baseURL      string
+	timeout      time.Duration
+	retryOnEmpty bool
+}
+
+type providerStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Reason says why a known provider is not in the race.
+	Reason    string `json:"reason,omitempty"`
+	BaseURL   string `json:"base_url,omitempty"`
+	TimeoutMs int64  `json:"timeout_ms,omitempty"`
+	Preferred bool   `json:"preferred,omitempty"`
+	Primary   bool   `json:"primary,omitempty"`
+	// RetryOnEmpty is set for the providers in -retry-on-empty.
+	RetryOnEmpty bool `json:"retry_on_empty,omitempty"`
+	// Breaker is the circuit state, absent when breakers are disabled.
+	Breaker           string   `json:"breaker,omitempty"`
+	RecentSuccessRate *float64 `json:"recent_success_rate,omitempty"`
+	// HealthScore is the weighted success rate -health-window skips
+	// providers by, absent when it is disabled or before the first call.
+	HealthScore *float64         `json:"health_score,omitempty"`
+	Stats       *providerSummary `json:"stats,omitempty"`
+}
+
+// handleProviders shows which providers take part in the race and how
+// they are doing right now, to tell why one of them isn't answering: it
+// wasn't configured, its circuit is open, or it has been failing.
+func (s *server) handleProviders(w http.ResponseWriter, r *http.Request) {
+	summaries := s.stats.snapshot()
+	recent := s.stats.recent()
+	scores := s.resolver.Health.Scores()
+	var statuses []providerStatus
+	for _, p := range s.resolver.Providers {
+		name := p.Name()
+		cfg := s.providerConfigs[name]
+		status := providerStatus{
+			Name:         name,
+			Enabled:      s.resolver.Switches.Enabled(name),
+			BaseURL:      cfg.baseURL,
+			TimeoutMs:    cfg.timeout.Milliseconds(),
+			Preferred:    name == s.resolver.Preferred,
+			Primary:      name == s.resolver.Primary,
+			RetryOnEmpty: cfg.retryOnEmpty,
+		}
+		if !status.Enabled {
+			status.Reason = "desligado (-providers ou PUT /providers/{nome})"
+		}
+		if b, ok := s.resolver.Breakers[name]; ok {
+			status.Breaker = b.State().String()
+		}
+		if rate, ok := recent[name]; ok {
+			status.RecentSuccessRate = &rate
+		}
+		if score, ok := scores[name]; ok {
+			status.HealthScore = &score
+		}
+		if sum, ok := summaries[name]; ok {
+			status.Stats = &sum
+		}
+		statuses = append(statuses, status)
+	}
+	// A known provider is left out either because -mock replaced them all
+	// or, for correios, because it has no credentials.
+	listed := func(name string) bool {
+		return slices.ContainsFunc(statuses, func(st providerStatus) bool { return st.Name == name })
+	}
+	mock := listed(mockProvider{}.Name())
+	for _, name := range providerNames {
+		if listed(name) {
+			continue
+		}
+		reason := "credenciais não configuradas (-correios-user e -correios-access-code)"
+		if mock {
+			reason = "substituído pelo provedor falso (-mock)"
+		}
+		statuses = append(statuses, providerStatus{Name: name, Reason: reason})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"providers": statuses})
+}
+
+// handleProviderSwitch serves PUT /providers/{name}, turning a provider on
+// or off for the lookups that start afterwards, e.g. to pull one out of
+// the race during its outage. The change is lost on restart, where
+// -providers applies again.
+func (s *server) handleProviderSwitch(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !slices.ContainsFunc(s.resolver.Providers, func(p cep.Provider) bool { return p.Name() == name }) {
+		writeError(w, r, http.StatusNotFound, errCodeProviderNotFound, "Provedor não registrado: "+name)
+		return
+	}
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, `Corpo inválido: esperado {"enabled": true} ou {"enabled": false}`)
+		return
+	}
+	s.resolver.Switches.Set(name, *body.Enabled)
+	slog.InfoContext(r.Context(), "provedor alternado", "provider", name, "enabled", *body.Enabled)
+	w.WriteHeader(http.StatusNoContent)
+}
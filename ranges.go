@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+type rangesResponse struct {
+	UF     string      `json:"uf"`
+	Ranges []cep.Range `json:"ranges"`
+}
+
+// handleRanges serves /states/{uf}/ranges with the CEP ranges the Correios
+// assign to a state. No public provider publishes ranges per city, so a
+// state is as fine-grained as this gets; the table is built in, so unlike
+// the lookups it never reaches the providers.
+func (s *server) handleRanges(w http.ResponseWriter, r *http.Request) {
+	uf := strings.ToUpper(strings.TrimSpace(r.PathValue("uf")))
+	ranges := cep.StateRanges(uf)
+	if ranges == nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidAddress, "uf deve ser a sigla de um estado, ex. SP")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rangesResponse{UF: uf, Ranges: ranges})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const rateLimitIdle = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps one token bucket per client IP. At most maxClients
+// buckets are kept: when full, idle clients are dropped first and then the
+// least recently seen one.
+type rateLimiter struct {
+	mu         sync.Mutex
+	limit      rate.Limit
+	burst      int
+	maxClients int
+	clients    map[string]*clientLimiter
+}
+
+func newRateLimiter(perSecond float64, burst, maxClients int) *rateLimiter {
+	return &rateLimiter{
+		limit:      rate.Limit(perSecond),
+		burst:      burst,
+		maxClients: maxClients,
+		clients:    make(map[string]*clientLimiter),
+	}
+}
+
+// allow reports whether ip may spend n tokens now and, if not, how long it
+// should wait before retrying. A plain request costs one; routes that fan
+// out to several lookups charge the extra ones themselves.
+func (l *rateLimiter) allow(ip string, n int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c, ok := l.clients[ip]
+	if !ok {
+		if len(l.clients) >= l.maxClients {
+			l.evict(now)
+		}
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+
+	res := c.limiter.ReserveN(now, n)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+func (l *rateLimiter) evict(now time.Time) {
+	var oldestIP string
+	var oldest time.Time
+	for ip, c := range l.clients {
+		if now.Sub(c.lastSeen) > rateLimitIdle {
+			delete(l.clients, ip)
+			continue
+		}
+		if oldestIP == "" || c.lastSeen.Before(oldest) {
+			oldestIP, oldest = ip, c.lastSeen
+		}
+	}
+	if len(l.clients) >= l.maxClients {
+		delete(l.clients, oldestIP)
+	}
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := l.allow(clientIP(r), 1)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, r, http.StatusTooManyRequests, errCodeRateLimited, "Limite de requisições excedido, tente novamente mais tarde")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	cacheBackendMemory = "memory"
+	cacheBackendRedis  = "redis"
+
+	redisKeyPrefix = "cep:"
+	// redisTimeout keeps an unreachable Redis from eating the lookup's
+	// budget: past it the cache is skipped and the providers are raced.
+	redisTimeout = 200 * time.Millisecond
+	// redisBackoff is how long Redis is skipped after a failure, so a
+	// Redis that is down doesn't cost every lookup a dial.
+	redisBackoff = 5 * time.Second
+)
+
+// redisCache shares cached addresses between instances. Redis errors are
+// logged and count as misses, so lookups keep working while it is down.
+type redisCache struct {
+	client *redis.Client
+	// stale keeps keys this long past their TTL so Stale can serve them.
+	stale time.Duration
+	// downUntil is when, in Unix nanoseconds, Redis is tried again after a
+	// failure; zero while it works. Only the first failure and the
+	// recovery are logged.
+	downUntil atomic.Int64
+}
+
+// redisEntry is the stored value: the address plus its freshness, since
+// the key itself outlives the TTL when stale entries are kept.
+type redisEntry struct {
+	Address cep.Address `json:"address"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"`
+}
+
+// newRedisCache connects lazily, so a Redis that is down at startup is
+// just a cache that misses until it comes up. Keys are kept for stale past
+// their TTL.
+func newRedisCache(rawURL string, stale time.Duration) (*redisCache, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	opts.DialTimeout = redisTimeout
+	opts.ReadTimeout = redisTimeout
+	opts.WriteTimeout = redisTimeout
+	opts.MaxRetries = -1
+	opts.DialerRetries = 1
+	redis.SetLogger(redisLogger{})
+	return &redisCache{client: redis.NewClient(opts), stale: stale}, nil
+}
+
+func (c *redisCache) Get(ctx context.Context, code string) (cep.Address, bool) {
+	entry, ok := c.get(ctx, code)
+	if !ok || time.Now().After(entry.Expires) {
+		return cep.Address{}, false
+	}
+	return entry.Address, true
+}
+
+func (c *redisCache) Stale(ctx context.Context, code string) (cep.Address, time.Time, bool) {
+	entry, ok := c.get(ctx, code)
+	return entry.Address, entry.Stored, ok
+}
+
+func (c *redisCache) get(ctx context.Context, code string) (redisEntry, bool) {
+	if !c.available() {
+		return redisEntry{}, false
+	}
+	raw, err := c.client.Get(ctx, redisKeyPrefix+code).Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.ok(ctx)
+		return redisEntry{}, false
+	}
+	if err != nil {
+		c.fail(ctx, err)
+		return redisEntry{}, false
+	}
+	c.ok(ctx)
+	var entry redisEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		slog.WarnContext(ctx, "entrada inválida no cache redis", "cep", code, "err", err)
+		return redisEntry{}, false
+	}
+	// Entries written before they carried their expiry are misses, and
+	// get replaced by the lookup that follows.
+	return entry, !entry.Expires.IsZero()
+}
+
+func (c *redisCache) Set(ctx context.Context, code string, address cep.Address, ttl time.Duration) {
+	now := time.Now()
+	raw, err := json.Marshal(redisEntry{Address: address, Stored: now, Expires: now.Add(ttl)})
+	if err != nil || !c.available() {
+		return
+	}
+	if err := c.client.Set(ctx, redisKeyPrefix+code, raw, ttl+c.stale).Err(); err != nil {
+		c.fail(ctx, err)
+		return
+	}
+	c.ok(ctx)
+}
+
+func (c *redisCache) Delete(ctx context.Context, code string) error {
+	return c.client.Del(ctx, redisKeyPrefix+code).Err()
+}
+
+// Clear deletes only this service's keys, so a Redis shared with other
+// applications keeps their data.
+func (c *redisCache) Clear(ctx context.Context) error {
+	iter := c.client.Scan(ctx, 0, redisKeyPrefix+"*", 500).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 500 {
+			if err := c.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return c.client.Del(ctx, keys...).Err()
+	}
+	return nil
+}
+
+func (c *redisCache) Close() error {
+	return c.client.Close()
+}
+
+func (c *redisCache) available() bool {
+	until := c.downUntil.Load()
+	return until == 0 || time.Now().UnixNano() >= until
+}
+
+func (c *redisCache) fail(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	if c.downUntil.Swap(time.Now().Add(redisBackoff).UnixNano()) == 0 {
+		slog.WarnContext(ctx, "cache redis indisponível, consultando os provedores diretamente", "err", err)
+	}
+}
+
+func (c *redisCache) ok(ctx context.Context) {
+	if c.downUntil.Swap(0) != 0 {
+		slog.InfoContext(ctx, "cache redis restabelecido")
+	}
+}
+
+// redisLogger demotes the client's own pool messages to debug; failures
+// reach the log once through redisCache.fail.
+type redisLogger struct{}
+
+func (redisLogger) Printf(ctx context.Context, format string, v ...any) {
+	slog.DebugContext(ctx, "redis: "+fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+)
+
+// negotiateFormat picks the response format from an Accept header, honoring
+// q-values. ok is false when the header only lists unsupported types; an
+// empty header means JSON.
+func negotiateFormat(accept string) (format string, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		var f string
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			f = formatJSON
+		case "application/xml", "text/xml":
+			f = formatXML
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	if best == "" {
+		return formatJSON, false
+	}
+	return best, true
+}
+
+func writeFormatted(w http.ResponseWriter, format string, status int, v any) error {
+	if format == formatXML {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(status)
+		if _, err := w.Write([]byte(xml.Header)); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
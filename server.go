@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+	"github.com/coder/websocket"
+	"golang.org/x/sync/singleflight"
+)
+
+// Where an answer came from, in the source field of responses; X-Cache
+// carries the same as MISS, HIT or STALE.
+const (
+	servedProvider = "provider"
+	servedCache    = "cache"
+	servedStale    = "stale"
+)
+
+// cacheHeader maps each provenance to its X-Cache value.
+var cacheHeader = map[string]string{
+	servedProvider: "MISS",
+	servedCache:    "HIT",
+	servedStale:    "STALE",
+}
+
+type resultadoAPI struct {
+	XMLName xml.Name    `json:"-" xml:"resultado"`
+	Origem  string      `json:"origem" xml:"origem"`
+	Data    cep.Address `json:"data" xml:"data"`
+	// Provenance says whether a provider, the cache or an expired cache
+	// entry answered: servedProvider, servedCache or servedStale.
+	Provenance string `json:"source" xml:"source"`
+	DurationMs int64  `json:"duracao_ms,omitempty" xml:"duracao_ms,omitempty"`
+	Err        error  `json:"erro,omitempty" xml:"-"`
+	// Raw is the provider's own body, present only for debug=raw.
+	Raw json.RawMessage `json:"raw,omitempty" xml:"-"`
+	// CachedAt is set when every provider failed and the answer is an
+	// expired cache entry stored at that time.
+	CachedAt *time.Time `json:"cached_at,omitempty" xml:"cached_at,omitempty"`
+}
+
+type server struct {
+	resolver *cep.Resolver
+	searcher addressSearcher
+	stats    *providerStats
+	// providerConfigs holds each provider's settings for /providers.
+	providerConfigs map[string]providerConfig
+	history         *historyStore
+	flights         singleflight.Group
+
+	streamOptions websocket.AcceptOptions
+	stopping      chan struct{}
+	stopOnce      sync.Once
+	// streams counts the open websockets, which Shutdown doesn't wait for;
+	// streamsMu keeps one from opening while waitStreams waits.
+	streamsMu sync.Mutex
+	streams   sync.WaitGroup
+	timeout   time.Duration
+	cache     addressCache
+	cacheTTL  time.Duration
+	// serveStale is how long past its TTL a cached address may still
+	// answer a lookup that every provider failed; zero disables it.
+	serveStale time.Duration
+	limiter    *rateLimiter
+
+	batchMax         int
+	batchMaxBytes    int64
+	idempotency      *idempotencyStore
+	batchConcurrency int
+
+	// nearby* bound /cep/{cep}/nearby: the largest radius and result
+	// count a client may ask for, and how many neighbouring CEPs are
+	// looked up to find them.
+	nearbyMaxRadius  float64
+	nearbyMaxResults int
+	nearbyProbes     int
+
+	strictAccept bool
+	jsonp        bool
+	// debugRaw enables debug=raw on /cep.
+	debugRaw bool
+	maxAge   time.Duration
+
+	adminToken string
+}
+
+func (s *server) handleCEP(w http.ResponseWriter, r *http.Request) {
+	raw, ok := cepFromRequest(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, "Uso correto: /cep/{cep} ou /cep?cep={cep}")
+		return
+	}
+	if strings.Contains(raw, ",") {
+		s.serveCEPList(w, r, raw)
+		return
+	}
+	s.serveCEP(w, r, raw)
+}
+
+// cepBodyMax caps the body of POST /cep, which only carries one CEP.
+const cepBodyMax = 1 << 10
+
+// handleCEPBody serves POST /cep for clients that send {"cep": "..."}
+// rather than build a path; from there on it is handleCEP.
+func (s *server) handleCEPBody(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Cep string `json:"cep"`
+	}
+	const usage = `Corpo inválido: esperado {"cep": "01001000"}`
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeBodyError(w, r, err, usage)
+		return
+	}
+	if body.Cep == "" {
+		writeError(w, r, http.StatusBadRequest, errCodeBadRequest, usage)
+		return
+	}
+	s.serveCEP(w, r, body.Cep)
+}
+
+// serveCEP answers the lookup of raw, however the request carried it.
+func (s *server) serveCEP(w http.ResponseWriter, r *http.Request, raw string) {
+	code, err := cep.Normalize(raw)
+	if err != nil {
+		status, errCode := normalizeError(err)
+		writeError(w, r, status, errCode, err.Error())
+		return
+	}
+	format, ok := negotiateFormat(r.Header.Get("Accept"))
+	if !ok && s.strictAccept {
+		writeError(w, r, http.StatusNotAcceptable, errCodeNotAcceptable, "Formato não suportado: use application/json ou application/xml")
+		return
+	}
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidFields, err.Error())
+		return
+	}
+	lang, err := parseLang(r.URL.Query().Get("lang"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidLang, err.Error())
+		return
+	}
+	callback := ""
+	if s.jsonp && format == formatJSON {
+		callback = r.URL.Query().Get("callback")
+		if callback != "" && !validCallback(callback) {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidCallback, "callback inválido: use um identificador JavaScript, ex. app.onCep")
+			return
+		}
+	}
+
+	debugRaw := s.debugRaw && r.URL.Query().Get("debug") == "raw"
+
+	lookupsTotal.Inc()
+	start := time.Now()
+	var result resultadoAPI
+	if debugRaw {
+		result = s.lookupRaw(r.Context(), code)
+	} else {
+		result = s.lookup(r.Context(), code)
+	}
+	duration := time.Since(start)
+	lookupDuration.Observe(duration.Seconds())
+	if result.Err != nil {
+		slog.InfoContext(r.Context(), "consulta de CEP falhou", "cep", code, "duration_ms", duration.Milliseconds(), "err", result.Err)
+		status, errCode, message := lookupError(r.Context(), result.Err)
+		writeError(w, r, status, errCode, message)
+		return
+	}
+	slog.InfoContext(r.Context(), "consulta de CEP", "cep", code, "provider", result.Origem, "duration_ms", duration.Milliseconds())
+	s.recordHistory(r, code, result, duration)
+	result.Data.Cep = presentCEP(r, code)
+
+	// Outer middleware reads the winner from the header instead of parsing
+	// the body; it is set before the 304 check so revalidations carry it.
+	w.Header().Set(sourceHeader, result.Origem)
+	w.Header().Set("X-Cache", cacheHeader[result.Provenance])
+	w.Header().Add("Vary", "Accept")
+	switch {
+	case debugRaw:
+		w.Header().Set("Cache-Control", "no-store")
+	case result.CachedAt != nil:
+		markStale(w, *result.CachedAt)
+	default:
+		etag := addressETag(result.Data, format+callback+lang+fieldsKey(fields))
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.maxAge.Seconds())))
+		w.Header().Set("ETag", etag)
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	var body any = result
+	if fields != nil || lang != langEN {
+		body = partialResult{Origem: result.Origem, Data: partialAddress{result.Data, fields, lang}, Provenance: result.Provenance, DurationMs: result.DurationMs, Raw: result.Raw, CachedAt: result.CachedAt}
+	}
+	if callback != "" {
+		writeJSONP(w, callback, http.StatusOK, body)
+		return
+	}
+	writeFormatted(w, format, http.StatusOK, body)
+}
+
+// sourceHeader names the provider that answered a successful lookup, or
+// the one whose answer was cached.
+const sourceHeader = "X-CEP-Source"
+
+// presentCEP gives responses a consistent cep field whatever form the
+// provider used: the 8 digits by default, 12345-678 with format=pretty.
+func presentCEP(r *http.Request, code string) string {
+	if r.URL.Query().Get("format") == "pretty" {
+		return cep.Format(code)
+	}
+	return code
+}
+
+// cepFromRequest takes the CEP from the path segment after /cep/, falling
+// back to the cep query parameter when the segment is empty.
+func cepFromRequest(r *http.Request) (string, bool) {
+	raw := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/cep"), "/")
+	if raw == "" {
+		raw = r.URL.Query().Get("cep")
+	}
+	if raw == "" || strings.Contains(raw, "/") {
+		return "", false
+	}
+	return raw, true
+}
+
+// lookup serves code from the cache when possible and otherwise races the
+// providers, caching the winner. Concurrent lookups of the same CEP share a
+// single race, so a stampede on a popular CEP that just expired costs one
+// round of upstream calls.
+func (s *server) lookup(ctx context.Context, code string) resultadoAPI {
+	if result, ok := s.cached(ctx, code); ok {
+		return result
+	}
+	return s.race(ctx, code)
+}
+
+// cached returns the cache entry for code, if there is one.
+func (s *server) cached(ctx context.Context, code string) (resultadoAPI, bool) {
+	if s.cache == nil {
+		return resultadoAPI{}, false
+	}
+	address, ok := s.cache.Get(ctx, code)
+	if !ok {
+		return resultadoAPI{}, false
+	}
+	return resultadoAPI{Origem: address.Source, Data: address, Provenance: servedCache}, true
+}
+
+// race looks code up on the providers, sharing the race with concurrent
+// lookups of the same CEP, and caches the answer.
+func (s *server) race(ctx context.Context, code string) resultadoAPI {
+	// The shared race must not die with whichever caller started it, so it
+	// ignores that caller's cancellation; the resolver timeout still bounds
+	// it, and each caller stops waiting when its own ctx is done.
+	// Lookups with a client timeout only share races with the same one, so
+	// nobody gets a shorter deadline than they asked for, and likewise
+	// with required fields, so nobody settles for a less complete answer.
+	key := code
+	if d, ok := cep.LookupTimeout(ctx); ok {
+		key += "@" + d.String()
+	}
+	if fields, ok := cep.LookupRequired(ctx); ok {
+		key += "+" + strings.Join(fields, ",")
+	}
+	ch := s.flights.DoChan(key, func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		result, err := s.resolver.Resolve(ctx, code)
+		if err == nil && s.cache != nil {
+			s.cache.Set(ctx, code, result.Address, s.cacheTTL)
+		}
+		return result, err
+	})
+	select {
+	case <-ctx.Done():
+		return resultadoAPI{Err: ctx.Err()}
+	case res := <-ch:
+		if res.Err != nil {
+			if stale, ok := s.stale(ctx, code, res.Err); ok {
+				return stale
+			}
+			return resultadoAPI{Err: res.Err}
+		}
+		result := res.Val.(cep.Result)
+		return resultadoAPI{Origem: result.Provider, Data: result.Address, Provenance: servedProvider, DurationMs: result.Duration.Milliseconds()}
+	}
+}
+
+// stale falls back to an expired cache entry, up to serveStale past its
+// TTL, when the providers couldn't answer. A CEP they all reported as
+// not found is not papered over with an old answer.
+func (s *server) stale(ctx context.Context, code string, err error) (resultadoAPI, bool) {
+	if s.serveStale <= 0 || s.cache == nil || errors.Is(err, cep.ErrNotFound) {
+		return resultadoAPI{}, false
+	}
+	address, stored, ok := s.cache.Stale(ctx, code)
+	if !ok || time.Since(stored) > s.cacheTTL+s.serveStale {
+		return resultadoAPI{}, false
+	}
+	slog.WarnContext(ctx, "provedores falharam, servindo endereço expirado do cache", "cep", code, "cached_at", stored, "err", err)
+	return resultadoAPI{Origem: address.Source, Data: address, Provenance: servedStale, CachedAt: &stored}, true
+}
+
+// markStale flags a response built from an expired cache entry, per RFC
+// 7234's Warning and Age, so clients and caches don't take it for fresh.
+func markStale(w http.ResponseWriter, cachedAt time.Time) {
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(cachedAt).Seconds())))
+	w.Header().Set("Cache-Control", "no-cache")
+}
+
+// lookupTimeout is the timeout for upstream calls made under ctx: the one
+// the client asked for with X-Timeout-Ms, or -timeout.
+func (s *server) lookupTimeout(ctx context.Context) time.Duration {
+	if d, ok := cep.LookupTimeout(ctx); ok {
+		return d
+	}
+	return s.timeout
+}
+
+// lookupRaw races the providers for debug=raw, keeping the winner's body.
+// It skips the cache, which holds no bodies, and the shared race, so the
+// body never reaches callers that didn't ask for it.
+func (s *server) lookupRaw(ctx context.Context, code string) resultadoAPI {
+	result, err := s.resolver.Resolve(cep.WithRaw(ctx), code)
+	if err != nil {
+		return resultadoAPI{Err: err}
+	}
+	return resultadoAPI{Origem: result.Provider, Data: result.Address, Provenance: servedProvider, DurationMs: result.Duration.Milliseconds(), Raw: result.Raw}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+)
+
+// providerStats aggregates per-provider counters since process start for
+// /stats, a quick look at the providers without a Prometheus server.
+type providerStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	providers map[string]*providerCounters
+}
+
+// recentCalls is how many of a provider's latest completed calls the
+// recent success rate covers.
+const recentCalls = 100
+
+type providerCounters struct {
+	requests  int64
+	successes int64
+	failures  int64
+	cancelled int64
+	aborted   int64
+	wins      int64
+	latency   time.Duration
+
+	// recent is a ring of the latest completed calls, true for successes.
+	recent     [recentCalls]bool
+	recentLen  int
+	recentNext int
+}
+
+func (c *providerCounters) remember(ok bool) {
+	c.recent[c.recentNext] = ok
+	c.recentNext = (c.recentNext + 1) % recentCalls
+	c.recentLen = min(c.recentLen+1, recentCalls)
+}
+
+// recentSuccessRate is the share of successes among the latest completed
+// calls; ok is false before the first one.
+func (c *providerCounters) recentSuccessRate() (rate float64, ok bool) {
+	if c.recentLen == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, success := range c.recent[:c.recentLen] {
+		if success {
+			n++
+		}
+	}
+	return float64(n) / float64(c.recentLen), true
+}
+
+type providerSummary struct {
+	Requests     int64   `json:"requests"`
+	Successes    int64   `json:"successes"`
+	Failures     int64   `json:"failures"`
+	Cancelled    int64   `json:"cancelled"`
+	Aborted      int64   `json:"aborted"`
+	Wins         int64   `json:"wins"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	WinRate      float64 `json:"win_rate"`
+}
+
+func newProviderStats() *providerStats {
+	return &providerStats{started: time.Now(), providers: make(map[string]*providerCounters)}
+}
+
+func (s *providerStats) counters(provider string) *providerCounters {
+	c, ok := s.providers[provider]
+	if !ok {
+		c = &providerCounters{}
+		s.providers[provider] = c
+	}
+	return c
+}
+
+// hooks records every provider call. Calls cancelled because another
+// provider won, and those whose caller gave up, are each counted apart, so
+// they neither count as failures nor skew the average latency.
+func (s *providerStats) hooks() cep.Hooks {
+	return cep.Hooks{
+		ProviderDone: func(provider string, d time.Duration, err error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			c := s.counters(provider)
+			c.requests++
+			switch {
+			case err == nil:
+				c.successes++
+			case errors.Is(err, cep.ErrLostRace):
+				c.cancelled++
+				return
+			case errors.Is(err, context.Canceled):
+				c.aborted++
+				return
+			default:
+				c.failures++
+			}
+			c.remember(err == nil)
+			c.latency += d
+		},
+		Won: func(provider string) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.counters(provider).wins++
+		},
+	}
+}
+
+func (s *providerStats) snapshot() map[string]providerSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := make(map[string]providerSummary, len(s.providers))
+	for name, c := range s.providers {
+		sum := providerSummary{
+			Requests:  c.requests,
+			Successes: c.successes,
+			Failures:  c.failures,
+			Cancelled: c.cancelled,
+			Aborted:   c.aborted,
+			Wins:      c.wins,
+		}
+		if completed := c.successes + c.failures; completed > 0 {
+			sum.AvgLatencyMs = float64(c.latency.Microseconds()) / 1000 / float64(completed)
+		}
+		if c.requests > 0 {
+			sum.WinRate = float64(c.wins) / float64(c.requests)
+		}
+		summaries[name] = sum
+	}
+	return summaries
+}
+
+// recent returns each provider's recent success rate, for the providers
+// that have completed a call.
+func (s *providerStats) recent() map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rates := make(map[string]float64, len(s.providers))
+	for name, c := range s.providers {
+		if rate, ok := c.recentSuccessRate(); ok {
+			rates[name] = rate
+		}
+	}
+	return rates
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{
+		"uptime_seconds": int64(time.Since(s.stats.started).Seconds()),
+		"providers":      s.stats.snapshot(),
+	}
+	if c, ok := s.cache.(*memoryCache); ok {
+		body["cache"] = c.stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// combineHooks calls each of hooks in turn.
+func combineHooks(hooks ...cep.Hooks) cep.Hooks {
+	return cep.Hooks{
+		ProviderDone: func(provider string, d time.Duration, err error) {
+			for _, h := range hooks {
+				if h.ProviderDone != nil {
+					h.ProviderDone(provider, d, err)
+				}
+			}
+		},
+		Won: func(provider string) {
+			for _, h := range hooks {
+				if h.Won != nil {
+					h.Won(provider)
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/HenriqueOtsuka/multithread/cep"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+const (
+	// streamReadLimit bounds a single client message, which is enough for
+	// a full batch of CEPs.
+	streamReadLimit = 64 << 10
+	// streamWriteTimeout drops clients that stop reading their results.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// handleBatchStream serves /ws/batch. The client sends messages holding a
+// CEP or a JSON array of CEPs and gets back one batchItem message per CEP
+// as soon as its race completes, in completion order. At most
+// batchConcurrency lookups run per connection; reading pauses while the
+// cap is reached. Closing the socket cancels the connection's lookups.
+func (s *server) handleBatchStream(w http.ResponseWriter, r *http.Request) {
+	if !s.openStream() {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeShuttingDown, "Servidor encerrando")
+		return
+	}
+	defer s.streams.Done()
+
+	// The connection outlives the server's per-request read and write
+	// timeouts, which would otherwise cut it after a few seconds.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, &s.streamOptions)
+	if err != nil {
+		slog.InfoContext(r.Context(), "websocket recusado", "err", err)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(streamReadLimit)
+
+	// After the upgrade net/http no longer cancels r.Context() when the
+	// client goes away, so the connection gets its own.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopping:
+			conn.Close(websocket.StatusGoingAway, "servidor encerrando")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	sem := make(chan struct{}, s.batchConcurrency)
+	var wg sync.WaitGroup
+	write := func(v any) {
+		wctx, wcancel := context.WithTimeout(ctx, streamWriteTimeout)
+		defer wcancel()
+		if err := wsjson.Write(wctx, conn, v); err != nil {
+			cancel()
+		}
+	}
+
+	for {
+		var msg json.RawMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			status := websocket.CloseStatus(err)
+			if status != websocket.StatusNormalClosure && status != websocket.StatusGoingAway && !errors.Is(err, context.Canceled) {
+				slog.InfoContext(ctx, "websocket encerrado", "err", err)
+			}
+			cancel()
+			wg.Wait()
+			return
+		}
+		ceps, err := parseStreamMessage(msg)
+		if err == nil && len(ceps) > s.batchMax {
+			err = fmt.Errorf("Lote excede o limite de %d CEPs", s.batchMax)
+		}
+		if err != nil {
+			write(errorResponse{Error: apiError{Code: errCodeBadRequest, Message: err.Error()}})
+			continue
+		}
+
+		for _, raw := range ceps {
+			code, err := cep.Normalize(raw)
+			if err != nil {
+				write(invalidItem(raw, err))
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				item := s.batchLookup(ctx, r, code)
+				if ctx.Err() == nil {
+					write(item)
+				}
+			}()
+		}
+	}
+}
+
+// parseStreamMessage accepts either a single CEP string or an array of
+// them.
+func parseStreamMessage(msg json.RawMessage) ([]string, error) {
+	var ceps []string
+	if err := json.Unmarshal(msg, &ceps); err == nil {
+		return ceps, nil
+	}
+	var single string
+	if err := json.Unmarshal(msg, &single); err != nil {
+		return nil, errors.New("mensagem inválida: esperado um CEP ou um array JSON de CEPs")
+	}
+	return []string{single}, nil
+}
+
+// streamAcceptOptions allows the configured CORS origins to open
+// websockets too; with none configured only same-origin pages can.
+func streamAcceptOptions(origins []string) websocket.AcceptOptions {
+	var opts websocket.AcceptOptions
+	for _, origin := range origins {
+		if origin == "*" {
+			opts.InsecureSkipVerify = true
+			continue
+		}
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			opts.OriginPatterns = append(opts.OriginPatterns, u.Host)
+		}
+	}
+	return opts
+}
+
+// openStream counts a new websocket in s.streams, unless the server is
+// already shutting down.
+func (s *server) openStream() bool {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	select {
+	case <-s.stopping:
+		return false
+	default:
+		s.streams.Add(1)
+		return true
+	}
+}
+
+// closeStreams tells open websockets that the server is shutting down.
+// Shutdown doesn't wait for hijacked connections, so they must be told.
+func (s *server) closeStreams() {
+	s.stopOnce.Do(func() {
+		s.streamsMu.Lock()
+		close(s.stopping)
+		s.streamsMu.Unlock()
+	})
+}
+
+// waitStreams closes the websockets and waits, up to ctx, for their
+// lookups to finish, since those still record history and fill the cache.
+// It reports whether they all did.
+func (s *server) waitStreams(ctx context.Context) bool {
+	s.closeStreams()
+	done := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}